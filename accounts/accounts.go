@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package accounts implements the player account file used for logging in.
+package accounts
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Account maps a player to a species and the roles granted by their tokens.
+type Account struct {
+	Username  string   `json:"username"`
+	Email     string   `json:"email"`
+	SpeciesId int      `json:"species_id"`
+	Roles     []string `json:"roles"`
+	Password  string   `json:"password"` // hash from HashPassword, never plain text
+}
+
+// Accounts is the set of accounts loaded from the player file.
+type Accounts struct {
+	accounts []*Account
+}
+
+// Load reads the player file. A missing file is not an error;
+// it just means that nobody can log in.
+func Load(path string) (*Accounts, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Accounts{}, nil
+		}
+		return nil, err
+	}
+	var list []*Account
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	usernames, emails := make(map[string]bool), make(map[string]bool)
+	for _, a := range list {
+		if a.Username == "" {
+			return nil, fmt.Errorf("%s: account with missing username", path)
+		} else if usernames[a.Username] {
+			return nil, fmt.Errorf("%s: duplicate username %q", path, a.Username)
+		}
+		usernames[a.Username] = true
+		if a.Email != "" {
+			email := strings.ToLower(a.Email)
+			if emails[email] {
+				return nil, fmt.Errorf("%s: duplicate email %q", path, a.Email)
+			}
+			emails[email] = true
+		}
+		if _, err := parseHash(a.Password); err != nil {
+			return nil, fmt.Errorf("%s: account %q: %w", path, a.Username, err)
+		}
+	}
+	return &Accounts{accounts: list}, nil
+}
+
+// Len returns the number of accounts.
+func (a *Accounts) Len() int {
+	if a == nil {
+		return 0
+	}
+	return len(a.accounts)
+}
+
+// Authenticate returns the account if the login (username or email) and password match.
+// Every failure returns ErrInvalidCredentials so that callers can't tell
+// an unknown user from a bad password.
+func (a *Accounts) Authenticate(login, password string) (*Account, error) {
+	var found *Account
+	if a != nil {
+		for _, acct := range a.accounts {
+			if subtle.ConstantTimeCompare([]byte(acct.Username), []byte(login)) == 1 || (acct.Email != "" && strings.EqualFold(acct.Email, login)) {
+				found = acct
+				break
+			}
+		}
+	}
+	if found == nil {
+		// hash anyway so that unknown users take as long as known ones
+		_ = CheckPassword(dummyHash, password)
+		return nil, ErrInvalidCredentials
+	} else if !CheckPassword(found.Password, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return found, nil
+}
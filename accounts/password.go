@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package accounts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Passwords are stored as "pbkdf2-sha256$<iterations>$<salt>$<key>"
+// with the salt and key encoded as unpadded base64.
+const (
+	hashPrefix     = "pbkdf2-sha256"
+	hashIterations = 100000
+	hashKeyLength  = 32
+	hashSaltLength = 16
+)
+
+var errBadHash = errors.New("invalid password hash")
+
+// dummyHash is checked against when the account doesn't exist.
+var dummyHash = mustHash("not-a-real-password")
+
+type passwordHash struct {
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+// HashPassword returns a hash of the password suitable for the player file.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, hashSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(password), salt, hashIterations, hashKeyLength)
+	return fmt.Sprintf("%s$%d$%s$%s", hashPrefix, hashIterations, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPassword returns true if the password matches the hash.
+func CheckPassword(hash, password string) bool {
+	ph, err := parseHash(hash)
+	if err != nil {
+		return false
+	}
+	key := pbkdf2([]byte(password), ph.salt, ph.iterations, len(ph.key))
+	return subtle.ConstantTimeCompare(key, ph.key) == 1
+}
+
+func mustHash(password string) string {
+	hash, err := HashPassword(password)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+func parseHash(hash string) (*passwordHash, error) {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 4 || fields[0] != hashPrefix {
+		return nil, errBadHash
+	}
+	var ph passwordHash
+	var err error
+	if ph.iterations, err = strconv.Atoi(fields[1]); err != nil || ph.iterations < 1 {
+		return nil, errBadHash
+	} else if ph.salt, err = base64.RawStdEncoding.DecodeString(fields[2]); err != nil {
+		return nil, errBadHash
+	} else if ph.key, err = base64.RawStdEncoding.DecodeString(fields[3]); err != nil || len(ph.key) == 0 {
+		return nil, errBadHash
+	}
+	return &ph, nil
+}
+
+// pbkdf2 implements PBKDF2 (RFC 8018) using HMAC-SHA256.
+func pbkdf2(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLength := prf.Size()
+	blocks := (keyLength + hashLength - 1) / hashLength
+
+	dk := make([]byte, 0, blocks*hashLength)
+	u := make([]byte, hashLength)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLength:]
+		copy(u, t)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLength]
+}
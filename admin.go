@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mdhender/fhdb/audit"
+	"github.com/mdhender/fhdb/handlers"
+	"github.com/mdhender/fhdb/ports"
+	"github.com/mdhender/fhdb/way"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// audited writes an audit log entry for every request, including the rejected ones.
+// It should run before Authenticate so that requests with bad credentials are
+// recorded too; Authenticate fills in the caller once it is known.
+// Handlers describe what they changed with handlers.Summarize.
+func (s *Server) audited(h http.Handler) http.Handler {
+	return handlers.Observe(h, func(r *http.Request, info *handlers.RequestInfo, status int, elapsed time.Duration) {
+		al := s.auditLog(r, info)
+		if al == nil {
+			return
+		}
+		route := way.Pattern(r.Context())
+		e := audit.Entry{
+			Time:      time.Now().UTC().Format(time.RFC3339),
+			RequestId: info.Id,
+			SpeciesId: info.SpeciesId,
+			Username:  info.Username,
+			Method:    r.Method,
+			Route:     route,
+			Path:      r.URL.Path,
+			Summary:   info.Summary,
+			Status:    status,
+			Outcome:   "ok",
+		}
+		if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			e.Outcome = "denied"
+		} else if status >= 400 {
+			e.Outcome = "failed"
+		}
+		if err := al.Append(e); err != nil {
+			log.Printf("[audit] %+v\n", err)
+		}
+	})
+}
+
+// auditLog returns the log for a request: the log of the game in the path,
+// else the log of the game the caller's token is for, else the server's log.
+func (s *Server) auditLog(r *http.Request, info *handlers.RequestInfo) *audit.Log {
+	if g, ok := s.games[way.Param(r.Context(), "game")]; ok {
+		return g.audit
+	} else if g, ok := s.games[info.Game]; ok {
+		return g.audit
+	}
+	return s.audit
+}
+
+// query the audit log. the turn defaults to the current turn.
+// results can be filtered by species, username, route, and outcome.
+func (s *Server) handleAdminGetAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		al := getGame(r).audit
+		if al == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		q := r.URL.Query()
+		turn, f := al.Turn(), audit.Filter{
+			Username: q.Get("username"),
+			Route:    q.Get("route"),
+			Outcome:  q.Get("outcome"),
+			Limit:    500,
+		}
+		for _, p := range []struct {
+			key string
+			val *int
+		}{
+			{"turn", &turn},
+			{"species", &f.SpeciesId},
+			{"limit", &f.Limit},
+		} {
+			if v := q.Get(p.key); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					http.Error(w, p.key+" must be a non-negative integer", http.StatusBadRequest)
+					return
+				}
+				*p.val = n
+			}
+		}
+		entries, err := al.Query(turn, f)
+		if err != nil {
+			log.Printf("[audit] %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		jsonOk(w, r, entries)
+	}
+}
+
+// handleAdminGetRoutes lists the registered routes and the authentication they require.
+// The routes are the same for every game, so game admins can read them too.
+func (s *Server) handleAdminGetRoutes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonOk(w, r, s.routeTable())
+	}
+}
+
+// list the sessions seen recently in every game.
+func (s *Server) handleAdminGetSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streams := make(map[string]map[int]int)
+		for _, g := range s.gameList() {
+			streams[g.id] = g.events.SubscribersBySpecies()
+		}
+		rsp := []*ports.AdminSessionResponse{}
+		for _, ts := range s.sessions.Sessions() {
+			rsp = append(rsp, &ports.AdminSessionResponse{
+				Game:      ts.Game,
+				TokenId:   ts.TokenId,
+				SpeciesId: ts.SpeciesId,
+				Username:  ts.Username,
+				Roles:     ts.Roles,
+				IssuedAt:  ts.IssuedAt.Format(time.RFC3339),
+				ExpiresAt: ts.ExpiresAt.Format(time.RFC3339),
+				FirstSeen: ts.FirstSeen.Format(time.RFC3339),
+				LastSeen:  ts.LastSeen.Format(time.RFC3339),
+				Remote:    ts.Remote,
+				Requests:  ts.Requests,
+				Streams:   streams[ts.Game][ts.SpeciesId],
+			})
+		}
+		jsonOk(w, r, rsp)
+	}
+}
+
+// get a species without applying fog-of-war
+func (s *Server) handleAdminGetSpecies() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := way.ParamInt(r.Context(), "id")
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		rsp, err := getGame(r).store().GetSpeciesDetail(id)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		jsonOk(w, r, rsp)
+	}
+}
+
+func (s *Server) handleAdminGetStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g := getGame(r)
+		rsp, err := g.store().GetStats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rsp.LoadedAt = g.readiness().LoadedAt
+		rsp.Subscribers = g.events.Subscribers()
+		jsonOk(w, r, rsp)
+	}
+}
+
+// reload the signing keys and every game.
+// a game that fails to load keeps its previous data; the response reports it.
+func (s *Server) handleAdminReload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.reloadKeys(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		failed := 0
+		for _, g := range s.gameList() {
+			if err := g.reload(); err != nil {
+				log.Printf("[reload] %s: %+v\n", g.id, err)
+				failed++
+			}
+		}
+		rsp := s.readiness()
+		handlers.Summarize(r, "reloaded keys and %d game(s), %d failed", len(rsp.Games), failed)
+		if failed != 0 {
+			jsonStatus(w, http.StatusInternalServerError, rsp)
+			return
+		}
+		jsonOk(w, r, rsp)
+	}
+}
+
+// reload the game in the path.
+func (s *Server) handleAdminReloadGame() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g := getGame(r)
+		if err := g.reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rsp := g.readiness()
+		handlers.Summarize(r, "reloaded data version %q turn %d", rsp.Version, rsp.TurnNumber)
+		jsonOk(w, r, rsp)
+	}
+}
+
+// save the game's data. the store can't be written yet, so this reports 501.
+func (s *Server) handleAdminSave() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g := getGame(r)
+		ds := g.store()
+		if ds == nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err := ds.Write(g.dir); errors.Is(err, ports.ErrNotImplemented) {
+			http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		handlers.Summarize(r, "saved data turn %d", ds.TurnNumber)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// set the current turn. the change is in memory only, so a reload or restart undoes it.
+func (s *Server) handleAdminSetTurn() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ports.TurnNumberRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		} else if req.TurnNumber < 0 {
+			http.Error(w, "turn_number must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		g := getGame(r)
+		var from int
+		if ds := g.store(); ds != nil {
+			from = ds.TurnNumber
+		}
+		if err := g.setTurnNumber(req.TurnNumber); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		handlers.Summarize(r, "set turn from %d to %d", from, req.TurnNumber)
+		rsp, err := g.store().GetTurnNumber()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonOk(w, r, rsp)
+	}
+}
+
+// auditedWrites is like audited, but skips requests that can't change state.
+func (s *Server) auditedWrites(h http.Handler) http.Handler {
+	audited := s.audited(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		audited.ServeHTTP(w, r)
+	})
+}
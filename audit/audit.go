@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package audit implements an append-only log of authenticated actions.
+// The log is archived whenever the turn changes, so that each turn's
+// actions can be reviewed on their own.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Entry is a single line in the audit log.
+type Entry struct {
+	Time      string `json:"time"`
+	Turn      int    `json:"turn"`
+	RequestId string `json:"request_id,omitempty"`
+	SpeciesId int    `json:"species_id"`
+	Username  string `json:"username,omitempty"`
+	Method    string `json:"method"`
+	Route     string `json:"route"`
+	Path      string `json:"path"`
+	Summary   string `json:"summary,omitempty"`
+	Status    int    `json:"status"`
+	Outcome   string `json:"outcome"`
+}
+
+// Filter selects entries from the log.
+// Zero values match everything.
+type Filter struct {
+	SpeciesId int
+	Username  string
+	Route     string
+	Outcome   string
+	Limit     int // return only the most recent entries
+}
+
+// Matches returns true if the entry passes the filter.
+func (f Filter) Matches(e Entry) bool {
+	if f.SpeciesId != 0 && e.SpeciesId != f.SpeciesId {
+		return false
+	} else if f.Username != "" && !strings.EqualFold(e.Username, f.Username) {
+		return false
+	} else if f.Route != "" && e.Route != f.Route {
+		return false
+	} else if f.Outcome != "" && e.Outcome != f.Outcome {
+		return false
+	}
+	return true
+}
+
+// Log appends entries to a file as JSON lines.
+type Log struct {
+	sync.Mutex
+	path string
+	turn int
+	fd   *os.File
+}
+
+// Open opens (or creates) the audit log for appending.
+// If the log holds entries from an earlier turn, they are archived first.
+func Open(path string, turn int) (*Log, error) {
+	l := &Log{path: path, turn: turn}
+	last, err := lastTurn(path)
+	if err != nil {
+		return nil, err
+	} else if last != 0 && last != turn {
+		if err := archive(path, ArchivePath(path, last)); err != nil {
+			return nil, err
+		}
+	}
+	if l.fd, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ArchivePath returns the name of the archive for a turn.
+// For example, "audit.log" for turn 8 is archived as "audit-turn-0008.log".
+func ArchivePath(path string, turn int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-turn-%04d%s", strings.TrimSuffix(path, ext), turn, ext)
+}
+
+// Append writes the entry to the log.
+// The entry's turn is set to the log's current turn.
+// Each entry is synced to disk since the log is used to settle disputes.
+func (l *Log) Append(e Entry) error {
+	l.Lock()
+	defer l.Unlock()
+	e.Turn = l.turn
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = l.fd.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return l.fd.Sync()
+}
+
+// Rotate archives the current log when the turn changes.
+// The log stays open while it's archived. It's opened for appending,
+// so entries written after the truncate land at the start of the file.
+// If the archive fails, entries keep going to the old turn's log.
+func (l *Log) Rotate(turn int) error {
+	l.Lock()
+	defer l.Unlock()
+	if turn == l.turn {
+		return nil
+	}
+	if err := archive(l.path, ArchivePath(l.path, l.turn)); err != nil {
+		return err
+	}
+	l.turn = turn
+	return nil
+}
+
+// Query returns the entries for a turn that match the filter, oldest first.
+// Entries for the current turn are read from the log, others from the archive.
+func (l *Log) Query(turn int, f Filter) ([]Entry, error) {
+	l.Lock()
+	path := l.path
+	if turn != l.turn {
+		path = ArchivePath(l.path, turn)
+	}
+	l.Unlock()
+
+	entries := []Entry{}
+	err := scan(path, func(e Entry) {
+		if f.Matches(e) {
+			entries = append(entries, e)
+		}
+	})
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[len(entries)-f.Limit:]
+	}
+	return entries, nil
+}
+
+// Turn returns the turn that new entries are logged against.
+func (l *Log) Turn() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.turn
+}
+
+// Close closes the log.
+func (l *Log) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.fd.Close()
+}
+
+// archive moves the entries in the log to the end of the archive.
+// Appending means that restarting twice in one turn can't lose entries.
+func archive(path, archivePath string) error {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	dst, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	} else if err = dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	} else if err = dst.Close(); err != nil {
+		return err
+	}
+	return os.Truncate(path, 0)
+}
+
+// lastTurn returns the turn of the last entry in the log, or zero if it's empty.
+func lastTurn(path string) (int, error) {
+	var turn int
+	err := scan(path, func(e Entry) {
+		turn = e.Turn
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return turn, err
+}
+
+// scan calls fn for each entry in the file.
+// Lines that can't be decoded are skipped.
+func scan(path string, fn func(Entry)) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fd.Close()
+	}()
+	sc := bufio.NewScanner(fd)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			fn(e)
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRotateFailure checks that the log keeps accepting entries for the
+// old turn when the archive can't be written, and rotates on a retry.
+func TestRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	if err := l.Append(Entry{Route: "/before"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a directory in the archive's place makes archive fail
+	archivePath := ArchivePath(path, 1)
+	if err := os.Mkdir(archivePath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Rotate(2); err == nil {
+		t.Fatalf("rotate: want error, got nil")
+	}
+	if got := l.Turn(); got != 1 {
+		t.Errorf("rotate: want turn 1 after failure, got %d", got)
+	}
+	if err := l.Append(Entry{Route: "/after"}); err != nil {
+		t.Fatalf("append after failed rotate: %v", err)
+	}
+
+	if err := os.Remove(archivePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Rotate(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Entry{Route: "/turn-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := l.Query(1, Filter{}); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 {
+		t.Errorf("archive: want 2 entries, got %d", len(entries))
+	}
+	if entries, err := l.Query(2, Filter{}); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 || entries[0].Route != "/turn-2" || entries[0].Turn != 2 {
+		t.Errorf("log: want only the turn 2 entry, got %+v", entries)
+	}
+}
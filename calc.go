@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mdhender/fhdb/config"
+	"github.com/mdhender/fhdb/ports"
+	"github.com/mdhender/fhdb/way"
+	"os"
+)
+
+// calcMishap returns the chance of a mishap when jumping between two systems.
+// The age is the age of the ship and gv is the species' gravitics level.
+func calcMishap(from, to way.XYZ, mishapAge, mishapGV int) ports.MishapResponse {
+	deltaX := from.X - to.X
+	deltaY := from.Y - to.Y
+	deltaZ := from.Z - to.Z
+	mishapChance := 100 * (deltaX*deltaX + deltaY*deltaY + deltaZ*deltaZ) / mishapGV
+
+	if mishapChance > 10000 {
+		mishapChance = 10000
+	} else if mishapAge > 0 {
+		// Add aging effect
+		successChance := 10000 - mishapChance
+		successChance -= (2 * mishapAge * successChance) / 100
+		if successChance < 0 {
+			successChance = 0
+		}
+		mishapChance = 10000 - successChance
+	}
+	rsp := ports.MishapResponse{
+		Age:          mishapAge,
+		GV:           mishapGV,
+		MishapChance: float64(mishapChance) / 100,
+	}
+	rsp.From.X = from.X
+	rsp.From.Y = from.Y
+	rsp.From.Z = from.Z
+	rsp.To.X = to.X
+	rsp.To.Y = to.Y
+	rsp.To.Z = to.Z
+	return rsp
+}
+
+// runCalc runs a game calculation without a server.
+func runCalc(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fhdb calc mishap [options]")
+	}
+	switch args[0] {
+	case "mishap":
+		return runCalcMishap(args[1:])
+	}
+	return fmt.Errorf("calc: unknown calculation %q", args[0])
+}
+
+// runCalcMishap prints the chance of a jump mishap.
+func runCalcMishap(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("calc mishap")
+	fromFlag := fs.String("from", "", "coordinates of the starting system, like \"1 2 3\"")
+	toFlag := fs.String("to", "", "coordinates of the destination system")
+	age := fs.Int("age", 0, "age of the ship")
+	gv := fs.Int("gv", 0, "gravitics tech level of the species")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 0 {
+		return fmt.Errorf("calc mishap: unexpected arguments %q", fs.Args())
+	}
+	from, ok := way.ParseXYZ(*fromFlag)
+	if !ok {
+		return fmt.Errorf("calc mishap: from must be three integers")
+	}
+	to, ok := way.ParseXYZ(*toFlag)
+	if !ok {
+		return fmt.Errorf("calc mishap: to must be three integers")
+	} else if *age < 0 {
+		return fmt.Errorf("calc mishap: age must be a non-negative integer")
+	} else if *gv < 1 {
+		return fmt.Errorf("calc mishap: gv must be a positive integer")
+	}
+
+	rsp := calcMishap(from, to, *age, *gv)
+	if *asJSON {
+		return json.NewEncoder(os.Stdout).Encode(rsp)
+	}
+	fmt.Printf("mishap chance: %.2f%%\n", rsp.MishapChance)
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mdhender/fhdb/config"
+	"os"
+	"text/tabwriter"
+)
+
+// runConfig inspects the server configuration.
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fhdb config show [-json] [server options]")
+	}
+	switch args[0] {
+	case "show":
+		return runConfigShow(args[1:])
+	}
+	return fmt.Errorf("config: unknown command %q", args[0])
+}
+
+// runConfigShow prints the effective server configuration, with the source
+// of each value, and then checks it.
+// The remaining arguments are the options that would be passed to serve.
+func runConfigShow(args []string) error {
+	asJSON := false
+	if len(args) != 0 && (args[0] == "-json" || args[0] == "--json") {
+		asJSON, args = true, args[1:]
+	}
+	cfg := config.Default()
+	settings, err := cfg.Settings(args)
+	if err != nil {
+		return err
+	}
+	verr := cfg.Validate()
+
+	if asJSON {
+		rsp := struct {
+			Settings []config.Setting `json:"settings"`
+			Problems []string         `json:"problems"`
+		}{Settings: settings, Problems: []string{}}
+		if ve, ok := verr.(*config.ValidationError); ok {
+			rsp.Problems = ve.Problems
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rsp); err != nil {
+			return err
+		}
+		return verr
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "OPTION\tVALUE\tSOURCE\n")
+	for _, st := range settings {
+		fmt.Fprintf(tw, "%s\t%q\t%s\n", st.Name, st.Value, st.Source)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if verr == nil {
+		fmt.Printf("configuration is valid\n")
+	}
+	return verr
+}
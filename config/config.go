@@ -23,29 +23,62 @@
 package config
 
 import (
-	"flag"
-	"fmt"
-	"github.com/peterbourgon/ff/v3"
-	"os"
-	"path/filepath"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Serve  bool
 	Debug  bool
 	Data   string
 	Server struct {
+		AccessLog struct {
+			File    string // empty means log to stdout
+			MaxSize int    // maximum size in megabytes before rotating, zero to never rotate
+			Keep    int    // number of rotated files to keep
+			// TrustRequestId reuses the X-Request-Id sent by an upstream proxy.
+			// Leave it off unless every request comes through a proxy that sets it.
+			TrustRequestId bool
+		}
+		Metrics struct {
+			Serve bool
+			Path  string
+		}
+		Record struct {
+			File string // empty means don't record requests
+		}
 		Scheme  string
 		Host    string
 		Port    int
 		Timeout struct {
-			Idle  time.Duration
-			Read  time.Duration
-			Write time.Duration
+			Idle     time.Duration
+			Read     time.Duration
+			Shutdown time.Duration
+			Write    time.Duration
+		}
+		Audit struct {
+			File string // for requests outside a game; empty means audit.log in the data directory
+		}
+		Cookies struct {
+			SameSite string // strict, lax, or none; see handlers.ParseSameSite
+		}
+		CORS struct {
+			Origins       []string // empty means only same-origin requests; see handlers.CORSPolicy
+			Credentials   []string // origins that may send cookies; cross-site origins also need Cookies.SameSite to be none
+			Headers       []string
+			ExposeHeaders []string
+			MaxAge        time.Duration
+		}
+		Events struct {
+			Heartbeat time.Duration
+			Poll      time.Duration
 		}
 		JWT struct {
-			Key string
+			Audience string // when set, tokens must include it in the aud claim
+			Issuer   string // when set, tokens must have it as the iss claim
+			Key      string
+			KeyFile  string        // when set, keys are loaded from this file instead of Key
+			Leeway   time.Duration // allowed clock skew when checking exp, nbf, and iat
+			TTL      time.Duration
 		}
 		TLS struct {
 			Serve    bool
@@ -60,67 +93,132 @@ type Config struct {
 
 // Default returns a default configuration.
 // These are the values without loading the environment, configuration file, or command line.
+// The data and web folders are next to the executable if they exist there,
+// otherwise they are in the user's data directory (see defaultDir).
 func Default() *Config {
 	var cfg Config
-	cfg.Data = "D:\\GoLand\\fhdb\\data"
+	cfg.Data = defaultDir("data")
+	cfg.Server.AccessLog.MaxSize = 64
+	cfg.Server.AccessLog.Keep = 5
+	cfg.Server.Cookies.SameSite = "strict"
+	cfg.Server.CORS.Headers = []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"}
+	cfg.Server.CORS.ExposeHeaders = []string{"Far-Horizons", "Retry-After", "X-Request-Id"}
+	cfg.Server.CORS.MaxAge = 10 * time.Minute
+	cfg.Server.JWT.Leeway = time.Minute
+	cfg.Server.JWT.TTL = 7 * 24 * time.Hour
+	cfg.Server.Metrics.Path = "/metrics"
 	cfg.Server.Scheme = "http"
 	cfg.Server.Host = "localhost"
 	cfg.Server.Port = 10801
 	cfg.Server.Timeout.Idle = 10 * time.Second
 	cfg.Server.Timeout.Read = 5 * time.Second
+	cfg.Server.Timeout.Shutdown = 30 * time.Second
 	cfg.Server.Timeout.Write = 10 * time.Second
-	cfg.Server.Web.Root = "D:\\GoLand\\fhdb\\web"
+	cfg.Server.Events.Heartbeat = 15 * time.Second
+	cfg.Server.Events.Poll = 30 * time.Second
+	cfg.Server.Web.Root = defaultDir("web")
 	return &cfg
 }
 
-// Load updates the values in a Config in this order:
-//   1. It will load a configuration file if one is given on the
-//      command line via the `-config` flag. If provided, the file
-//      must contain a valid JSON object.
-//   2. Environment variables, using the prefix `CONDUIT_RYER_SERVER`
-//   3. Command line flags
-func (cfg *Config) Load() error {
-	fs := flag.NewFlagSet("Server", flag.ExitOnError)
-	debug := fs.Bool("debug", cfg.Debug, "log debug information (optional)")
-	data := fs.String("data", cfg.Data, "path to application data")
+// Load updates the values in a Config with the options for the serve command.
+// The args are the command line without the command name.
+// Values are loaded in this order, with later values replacing earlier ones:
+//
+//  1. The configuration file, if one is given on the command line
+//     via the `-config` flag. It must contain a valid JSON object.
+//  2. Environment variables, using the prefix `FHOE`.
+//  3. Command line flags.
+//
+// Load doesn't check the values; call Validate for that.
+func (cfg *Config) Load(args []string) error {
+	_, err := cfg.load(args)
+	return err
+}
+
+// Settings loads the configuration the same way as Load and returns
+// every option with where its value came from. Secrets are redacted.
+func (cfg *Config) Settings(args []string) ([]Setting, error) {
+	fs, err := cfg.load(args)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Settings(), nil
+}
+
+// load loads the configuration and returns the flag set used to parse it.
+func (cfg *Config) load(args []string) (*FlagSet, error) {
+	fs := cfg.NewFlagSet("serve")
+	fs.JWTFlags()
+	serverAccessLogFile := fs.String("access-log", cfg.Server.AccessLog.File, "file to write access logs to (default stdout)")
+	serverAccessLogMaxSize := fs.Int("access-log-max-size", cfg.Server.AccessLog.MaxSize, "size in megabytes before rotating the access log (0 disables)")
+	serverAccessLogKeep := fs.Int("access-log-keep", cfg.Server.AccessLog.Keep, "number of rotated access logs to keep")
+	serverAccessLogTrustRequestId := fs.Bool("trust-request-id", cfg.Server.AccessLog.TrustRequestId, "reuse the X-Request-Id header sent by an upstream proxy instead of assigning a new id")
+	serverMetricsServe := fs.Bool("metrics", cfg.Server.Metrics.Serve, "serve metrics in Prometheus text format")
+	serverMetricsPath := fs.String("metrics-path", cfg.Server.Metrics.Path, "path to serve metrics on")
+	serverRecordFile := fs.String("record", cfg.Server.Record.File, "file to record api requests to for replay (optional)")
 	serverScheme := fs.String("scheme", cfg.Server.Scheme, "http scheme, either 'http' or 'https'")
 	serverHost := fs.String("host", cfg.Server.Host, "host name (or IP) to listen on")
 	serverPort := fs.Int("port", cfg.Server.Port, "port to listen on")
-	serverJWTKey := fs.String("jwt-key", cfg.Server.JWT.Key, "jwt hs256 key")
+	serverCORSOrigins := fs.String("cors-origins", strings.Join(cfg.Server.CORS.Origins, ","), "comma separated origins allowed to call the api, like 'https://*.example.com' (default same-origin only)")
+	serverCookiesSameSite := fs.String("cookie-samesite", cfg.Server.Cookies.SameSite, "samesite mode of the session cookie, one of 'strict', 'lax', or 'none' (use 'none' for cors-credentials origins on other sites)")
+	serverCORSCredentials := fs.String("cors-credentials", strings.Join(cfg.Server.CORS.Credentials, ","), "comma separated origins allowed to send cookies (origins on other sites also need cookie-samesite none)")
+	serverCORSHeaders := fs.String("cors-headers", strings.Join(cfg.Server.CORS.Headers, ","), "comma separated request headers allowed from other origins")
+	serverCORSExposeHeaders := fs.String("cors-expose-headers", strings.Join(cfg.Server.CORS.ExposeHeaders, ","), "comma separated response headers other origins may read")
+	serverCORSMaxAge := fs.Duration("cors-max-age", cfg.Server.CORS.MaxAge, "time browsers may cache preflight responses")
+	serverAuditFile := fs.String("audit-log", cfg.Server.Audit.File, "file to write the audit log for requests outside a game to (default audit.log in the data directory; each game has its own log in its folder)")
+	serverEventsHeartbeat := fs.Duration("sse-heartbeat", cfg.Server.Events.Heartbeat, "interval between heartbeats on event streams")
+	serverEventsPoll := fs.Duration("data-poll", cfg.Server.Events.Poll, "interval between checks for new data files (0 disables)")
 	serverTimeoutIdle := fs.Duration("idle-timeout", cfg.Server.Timeout.Idle, "http idle timeout")
 	serverTimeoutRead := fs.Duration("read-timeout", cfg.Server.Timeout.Read, "http read timeout")
+	serverTimeoutShutdown := fs.Duration("shutdown-timeout", cfg.Server.Timeout.Shutdown, "time to wait for requests to finish when shutting down")
 	serverTimeoutWrite := fs.Duration("write-timeout", cfg.Server.Timeout.Write, "http write timeout")
 	serverTLSServe := fs.Bool("https", cfg.Server.TLS.Serve, "serve https")
-	serverTLSCertFile := fs.String("https-cert-file", cfg.Server.Host, "https certificate file")
-	serverTLSKeyFile := fs.String("https-key-file", cfg.Server.Host, "https certificate key file")
+	serverTLSCertFile := fs.String("https-cert-file", cfg.Server.TLS.CertFile, "https certificate file")
+	serverTLSKeyFile := fs.String("https-key-file", cfg.Server.TLS.KeyFile, "https certificate key file")
 	serverWebRoot := fs.String("web", cfg.Server.Web.Root, "path to serve assets from")
 
-	if err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("FHOE"), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser)); err != nil {
-		return err
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	cfg.Debug = *debug
-	cfg.Data = filepath.Clean(*data)
+	cfg.Server.AccessLog.File = *serverAccessLogFile
+	cfg.Server.AccessLog.MaxSize = *serverAccessLogMaxSize
+	cfg.Server.AccessLog.Keep = *serverAccessLogKeep
+	cfg.Server.AccessLog.TrustRequestId = *serverAccessLogTrustRequestId
+	cfg.Server.Metrics.Serve = *serverMetricsServe
+	cfg.Server.Metrics.Path = *serverMetricsPath
+	cfg.Server.Record.File = *serverRecordFile
 	cfg.Server.Scheme = *serverScheme
 	cfg.Server.Host = *serverHost
 	cfg.Server.Port = *serverPort
-	cfg.Server.JWT.Key = *serverJWTKey
+	cfg.Server.Audit.File = *serverAuditFile
+	cfg.Server.Cookies.SameSite = *serverCookiesSameSite
+	cfg.Server.CORS.Origins = splitList(*serverCORSOrigins)
+	cfg.Server.CORS.Credentials = splitList(*serverCORSCredentials)
+	cfg.Server.CORS.Headers = splitList(*serverCORSHeaders)
+	cfg.Server.CORS.ExposeHeaders = splitList(*serverCORSExposeHeaders)
+	cfg.Server.CORS.MaxAge = *serverCORSMaxAge
+	cfg.Server.Events.Heartbeat = *serverEventsHeartbeat
+	cfg.Server.Events.Poll = *serverEventsPoll
 	cfg.Server.Timeout.Idle = *serverTimeoutIdle
 	cfg.Server.Timeout.Read = *serverTimeoutRead
+	cfg.Server.Timeout.Shutdown = *serverTimeoutShutdown
 	cfg.Server.Timeout.Write = *serverTimeoutWrite
 	cfg.Server.TLS.Serve = *serverTLSServe
 	cfg.Server.TLS.CertFile = *serverTLSCertFile
 	cfg.Server.TLS.KeyFile = *serverTLSKeyFile
 	cfg.Server.Web.Root = *serverWebRoot
 
-	if cfg.Server.TLS.Serve == true {
-		if cfg.Server.TLS.CertFile == "" {
-			return fmt.Errorf("must supply certificates file when serving HTTPS")
-		}
-		if cfg.Server.TLS.KeyFile == "" {
-			return fmt.Errorf("must supply certificate key file when serving HTTPS")
+	return fs, nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
 		}
 	}
-
-	return nil
+	return list
 }
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package config
+
+import (
+	"flag"
+	"github.com/peterbourgon/ff/v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FlagSet is the flag set for one command.
+// The options shared by every command are defined when it is created,
+// and Parse loads values from the configuration file and environment
+// as well as the command line.
+type FlagSet struct {
+	*flag.FlagSet
+	cfg     *Config
+	apply   []func()          // copy parsed values into the Config
+	secrets map[string]bool   // flags whose values must not be shown
+	sources map[string]string // where each flag's value came from, set by Parse
+}
+
+// envVarPrefix is the prefix for environment variables.
+const envVarPrefix = "FHOE"
+
+// Setting is one option of the effective configuration.
+type Setting struct {
+	Name   string `json:"name"`   // name of the flag
+	Value  string `json:"value"`  // redacted if the option is a secret
+	Source string `json:"source"` // default, flag, env, or config
+}
+
+// NewFlagSet returns a flag set for the command with the shared options,
+// which are -config, -data, and -debug.
+// Parsed values are stored in the Config.
+func (cfg *Config) NewFlagSet(name string) *FlagSet {
+	fs := &FlagSet{FlagSet: flag.NewFlagSet(name, flag.ExitOnError), cfg: cfg, secrets: make(map[string]bool)}
+	fs.String("config", "", "config file (optional)")
+	debug := fs.Bool("debug", cfg.Debug, "log debug information (optional)")
+	data := fs.String("data", cfg.Data, "path to application data")
+	fs.apply = append(fs.apply, func() {
+		cfg.Debug = *debug
+		cfg.Data = filepath.Clean(*data)
+	})
+	return fs
+}
+
+// JWTFlags adds the options for signing and checking tokens.
+func (fs *FlagSet) JWTFlags() {
+	cfg := fs.cfg
+	audience := fs.String("jwt-audience", cfg.Server.JWT.Audience, "audience required in tokens (optional)")
+	issuer := fs.String("jwt-issuer", cfg.Server.JWT.Issuer, "issuer required in tokens (optional)")
+	key := fs.String("jwt-key", cfg.Server.JWT.Key, "jwt hs256 key")
+	fs.secrets["jwt-key"] = true
+	keyFile := fs.String("jwt-key-file", cfg.Server.JWT.KeyFile, "file containing the jwt signing keys (overrides jwt-key)")
+	leeway := fs.Duration("jwt-leeway", cfg.Server.JWT.Leeway, "allowed clock skew when checking token times")
+	ttl := fs.Duration("jwt-ttl", cfg.Server.JWT.TTL, "lifetime of tokens issued at login")
+	fs.apply = append(fs.apply, func() {
+		cfg.Server.JWT.Audience = *audience
+		cfg.Server.JWT.Issuer = *issuer
+		cfg.Server.JWT.Key = *key
+		cfg.Server.JWT.KeyFile = *keyFile
+		cfg.Server.JWT.Leeway = *leeway
+		cfg.Server.JWT.TTL = *ttl
+	})
+}
+
+// Parse parses the configuration file, the environment (using the prefix FHOE),
+// and then the command line. Keys in the configuration file that the command
+// doesn't use are ignored, since one file is shared by all the commands.
+func (fs *FlagSet) Parse(args []string) error {
+	opts := []ff.Option{ff.WithEnvVarPrefix(envVarPrefix), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser), ff.WithIgnoreUndefined(true)}
+	if err := ff.Parse(fs.FlagSet, args, opts...); err != nil {
+		return err
+	}
+	fs.findSources(args)
+	for _, fn := range fs.apply {
+		fn()
+	}
+	return nil
+}
+
+// Settings returns every option with its value and where the value came from,
+// sorted by name. Secrets are redacted.
+func (fs *FlagSet) Settings() []Setting {
+	var list []Setting
+	fs.VisitAll(func(f *flag.Flag) { // VisitAll sorts by name
+		st := Setting{Name: f.Name, Value: f.Value.String(), Source: fs.sources[f.Name]}
+		if st.Source == "" {
+			st.Source = "default"
+		}
+		if fs.secrets[f.Name] && st.Value != "" {
+			st.Value = "[redacted]"
+		}
+		list = append(list, st)
+	})
+	return list
+}
+
+// findSources works out where each flag that was set got its value.
+// ff doesn't say, so this repeats its checks: the command line wins,
+// then the environment, and anything else came from the configuration file.
+func (fs *FlagSet) findSources(args []string) {
+	onCommandLine := make(map[string]bool)
+	probe := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	probe.SetOutput(ioutil.Discard)
+	fs.VisitAll(func(f *flag.Flag) {
+		bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+		probe.Var(&probeValue{name: f.Name, seen: onCommandLine, isBool: ok && bf.IsBoolFlag()}, f.Name, "")
+	})
+	_ = probe.Parse(args) // the real parse already succeeded
+
+	replacer := strings.NewReplacer("-", "_", ".", "_", "/", "_")
+	fs.sources = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if onCommandLine[f.Name] {
+			fs.sources[f.Name] = "flag"
+		} else if os.Getenv(envVarPrefix+"_"+replacer.Replace(strings.ToUpper(f.Name))) != "" {
+			fs.sources[f.Name] = "env"
+		} else {
+			fs.sources[f.Name] = "config"
+		}
+	})
+}
+
+// probeValue is a flag.Value that records that the flag was given.
+type probeValue struct {
+	name   string
+	seen   map[string]bool
+	isBool bool
+}
+
+func (p *probeValue) IsBoolFlag() bool {
+	return p.isBool
+}
+
+func (p *probeValue) Set(string) error {
+	p.seen[p.name] = true
+	return nil
+}
+
+func (p *probeValue) String() string {
+	return ""
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package config
+
+import (
+	"fmt"
+	"github.com/mdhender/fhdb/jwt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks the configuration for the serve command.
+// It reports every problem, not just the first.
+// The web root isn't checked because the server doesn't serve it yet.
+func (cfg *Config) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	// mustExist checks that a path exists and is (or isn't) a directory.
+	mustExist := func(name, path string, isDir bool) {
+		if sb, err := os.Stat(path); err != nil {
+			problem("%s: %v", name, err)
+		} else if isDir && !sb.IsDir() {
+			problem("%s: %q is not a directory", name, path)
+		} else if !isDir && sb.IsDir() {
+			problem("%s: %q is a directory", name, path)
+		}
+	}
+
+	mustExist("data", cfg.Data, true)
+	for _, f := range []struct{ name, path string }{
+		{"access-log", cfg.Server.AccessLog.File},
+		{"audit-log", cfg.Server.Audit.File},
+		{"record", cfg.Server.Record.File},
+	} {
+		if f.path != "" {
+			mustExist(f.name, filepath.Dir(f.path), true)
+		}
+	}
+	if cfg.Server.AccessLog.MaxSize < 0 {
+		problem("access-log-max-size: must not be negative")
+	}
+	if cfg.Server.AccessLog.Keep < 0 {
+		problem("access-log-keep: must not be negative")
+	}
+
+	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
+		problem("port: %d is not between 1 and 65535", cfg.Server.Port)
+	}
+	if cfg.Server.Scheme != "http" && cfg.Server.Scheme != "https" {
+		problem("scheme: must be either 'http' or 'https'")
+	}
+	if cfg.Server.TLS.Serve {
+		if cfg.Server.TLS.CertFile == "" {
+			problem("https-cert-file: must supply certificates file when serving HTTPS")
+		} else {
+			mustExist("https-cert-file", cfg.Server.TLS.CertFile, false)
+		}
+		if cfg.Server.TLS.KeyFile == "" {
+			problem("https-key-file: must supply certificate key file when serving HTTPS")
+		} else {
+			mustExist("https-key-file", cfg.Server.TLS.KeyFile, false)
+		}
+	}
+	for _, origin := range cfg.Server.CORS.Origins {
+		if origin != "*" && !validOrigin(origin) {
+			problem("cors-origins: %q is not an origin like 'https://example.com' or 'https://*.example.com'", origin)
+		}
+	}
+	for _, origin := range cfg.Server.CORS.Credentials {
+		if origin == "*" {
+			problem("cors-credentials: can't be '*'; list the origins that may send cookies")
+		} else if !validOrigin(origin) {
+			problem("cors-credentials: %q is not an origin like 'https://example.com' or 'https://*.example.com'", origin)
+		}
+	}
+	if len(cfg.Server.CORS.Credentials) != 0 && len(cfg.Server.CORS.Origins) == 0 {
+		problem("cors-credentials: has no effect unless cors-origins is set")
+	}
+	switch strings.ToLower(cfg.Server.Cookies.SameSite) {
+	case "strict", "lax", "none":
+	default:
+		problem("cookie-samesite: must be 'strict', 'lax', or 'none'")
+	}
+	if cfg.Server.CORS.MaxAge < 0 {
+		problem("cors-max-age: can't be negative")
+	}
+	if cfg.Server.Metrics.Serve && !strings.HasPrefix(cfg.Server.Metrics.Path, "/") {
+		problem("metrics-path: must start with '/'")
+	}
+
+	if cfg.Server.JWT.KeyFile != "" {
+		// loading the keyring checks secret lengths, key sizes, and curves.
+		if _, err := jwt.LoadKeyring(cfg.Server.JWT.KeyFile); err != nil {
+			problem("jwt-key-file: %v", err)
+		}
+	} else if len(cfg.Server.JWT.Key) < 16 {
+		problem("jwt-key: key length should be at least 16")
+	} else if isWeakKey(cfg.Server.JWT.Key) {
+		problem("jwt-key: key is a single repeated character or sequence")
+	}
+	if cfg.Server.JWT.TTL <= 0 {
+		problem("jwt-ttl: must be a positive duration")
+	}
+	if cfg.Server.JWT.Leeway < 0 {
+		problem("jwt-leeway: can't be negative")
+	}
+
+	if cfg.Server.Events.Heartbeat <= 0 {
+		problem("sse-heartbeat: must be a positive duration")
+	}
+	if cfg.Server.Events.Poll < 0 {
+		problem("data-poll: can't be negative")
+	}
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"idle-timeout", cfg.Server.Timeout.Idle},
+		{"read-timeout", cfg.Server.Timeout.Read},
+		{"write-timeout", cfg.Server.Timeout.Write},
+	} {
+		if t.value < 0 {
+			problem("%s: can't be negative", t.name)
+		}
+	}
+	if cfg.Server.Timeout.Shutdown <= 0 {
+		problem("shutdown-timeout: must be a positive duration")
+	}
+
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// isWeakKey returns true if the key is one character repeated,
+// or a sequence of up to 4 characters repeated, like "abcabcabc".
+// Longer repeated sequences aren't caught.
+func isWeakKey(key string) bool {
+	for n := 1; n <= 4 && n < len(key); n++ {
+		if strings.Repeat(key[:n], len(key)/n+1)[:len(key)] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// validOrigin returns true if s is a scheme and host with an optional port,
+// where the host may start with "*." to match subdomains.
+func validOrigin(s string) bool {
+	u, err := url.Parse(strings.Replace(s, "://*.", "://x.", 1))
+	if err != nil || u.Scheme == "" || u.Hostname() == "" {
+		return false
+	}
+	return u.User == nil && u.Path == "" && u.RawQuery == "" && u.Fragment == "" && !strings.Contains(u.Host, "*")
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/mdhender/fhdb/accounts"
+	"github.com/mdhender/fhdb/config"
+	"github.com/mdhender/fhdb/jwt"
+	"github.com/mdhender/fhdb/store/jsondb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// runValidate loads the files in the data folder the same way the server does
+// and reports every problem it finds. Every game is checked unless -game is given.
+func runValidate(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("validate")
+	gameId := fs.String("game", "", "game to check (default is every game)")
+	fs.JWTFlags()
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 0 {
+		return fmt.Errorf("validate: unexpected arguments %q", fs.Args())
+	}
+
+	var problems int
+	report := func(name string, err error) {
+		if err != nil {
+			fmt.Printf("%-24s %v\n", name+":", err)
+			problems++
+			return
+		}
+		fmt.Printf("%-24s ok\n", name+":")
+	}
+
+	ids, err := findGames(cfg.Data)
+	report("games", err)
+	if *gameId != "" {
+		ids = []string{*gameId}
+	}
+	for _, id := range ids {
+		dir := filepath.Join(cfg.Data, id)
+		ds, err := loadStore(dir)
+		report(id+"/galaxy.json", err)
+		if err == nil {
+			stats, _ := ds.GetStats()
+			fmt.Printf("  version %s, turn %d: %d systems, %d planets, %d species\n", stats.Version, stats.TurnNumber, stats.Systems, stats.Planets, stats.Species)
+			for _, warning := range stats.Warnings {
+				fmt.Printf("  warning: %s\n", warning)
+			}
+		}
+		_, err = accounts.Load(filepath.Join(dir, "players.json"))
+		report(id+"/players.json", err)
+	}
+	_, err = jwt.LoadRevocationList(filepath.Join(cfg.Data, "revoked.json"), cfg.Server.JWT.Leeway)
+	report("revoked.json", err)
+	if cfg.Server.JWT.KeyFile != "" {
+		_, err = jwt.LoadKeyring(cfg.Server.JWT.KeyFile)
+		report(filepath.Base(cfg.Server.JWT.KeyFile), err)
+	}
+
+	if problems != 0 {
+		return fmt.Errorf("validate: found %d problem(s) in %s", problems, cfg.Data)
+	}
+	return nil
+}
+
+// runImport validates a galaxy file and copies it into a game's folder.
+// A running server picks it up on its next data poll or admin reload.
+// Importing into a game that doesn't exist creates its folder;
+// the server only finds new games when it starts.
+func runImport(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("import")
+	gameId := gameFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		return fmt.Errorf("usage: fhdb import [options] galaxy.json")
+	}
+	var dir string
+	if *gameId != "" {
+		if !validGameId.MatchString(*gameId) {
+			return fmt.Errorf("import: %q is not a valid game id", *gameId)
+		}
+		dir = filepath.Join(cfg.Data, *gameId)
+	} else {
+		var err error
+		if dir, err = gameDir(cfg.Data, ""); err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+	}
+	src := fs.Arg(0)
+	ds, err := loadGalaxy(src)
+	if err != nil {
+		return fmt.Errorf("import: %s: %w", src, err)
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so the server never sees a partial file.
+	dst := filepath.Join(dir, "galaxy.json")
+	tmp := dst + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	} else if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	fmt.Printf("imported turn %d from %s into %s\n", ds.TurnNumber, src, dst)
+	return nil
+}
+
+// runExport writes a game's galaxy file to stdout or a file.
+// The file is validated and re-indented on the way out.
+func runExport(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("export")
+	gameId := gameFlag(fs)
+	output := fs.String("output", "", "file to write to (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 0 {
+		return fmt.Errorf("export: unexpected arguments %q", fs.Args())
+	}
+	dir, err := gameDir(cfg.Data, *gameId)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	jdb, err := jsondb.Read(filepath.Join(dir, "galaxy.json"))
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		return jdb.Write("*stdout*")
+	}
+	return jdb.Write(*output)
+}
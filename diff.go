@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mdhender/fhdb/config"
+	"github.com/mdhender/fhdb/store/jsondb"
+	"path/filepath"
+	"strconv"
+)
+
+// runDiff compares two galaxy files and prints the systems, planets,
+// and species that were added (+), removed (-), or changed (~).
+// With one file, it is compared to the galaxy file of the game given by -game.
+func runDiff(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("diff")
+	gameId := gameFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var oldFile, newFile string
+	switch fs.NArg() {
+	case 1:
+		dir, err := gameDir(cfg.Data, *gameId)
+		if err != nil {
+			return fmt.Errorf("diff: %w", err)
+		}
+		oldFile, newFile = fs.Arg(0), filepath.Join(dir, "galaxy.json")
+	case 2:
+		oldFile, newFile = fs.Arg(0), fs.Arg(1)
+	default:
+		return fmt.Errorf("usage: fhdb diff [options] old.json [new.json]")
+	}
+	from, err := jsondb.Read(oldFile)
+	if err != nil {
+		return fmt.Errorf("diff: %s: %w", oldFile, err)
+	}
+	to, err := jsondb.Read(newFile)
+	if err != nil {
+		return fmt.Errorf("diff: %s: %w", newFile, err)
+	}
+
+	if from.Version != to.Version {
+		fmt.Printf("~ version %s -> %s\n", from.Version, to.Version)
+	}
+	if from.Galaxy != nil && to.Galaxy != nil && from.Galaxy.TurnNumber != to.Galaxy.TurnNumber {
+		fmt.Printf("~ turn %d -> %d\n", from.Galaxy.TurnNumber, to.Galaxy.TurnNumber)
+	}
+
+	var total diffCount
+	oldSet, newSet := &diffSet{}, &diffSet{}
+	for _, s := range from.Systems {
+		oldSet.add(fmt.Sprintf("%d %d %d", s.Coords.X, s.Coords.Y, s.Coords.Z), s)
+	}
+	for _, s := range to.Systems {
+		newSet.add(fmt.Sprintf("%d %d %d", s.Coords.X, s.Coords.Y, s.Coords.Z), s)
+	}
+	total.add(oldSet.diff("system", newSet))
+
+	oldSet, newSet = &diffSet{}, &diffSet{}
+	for _, p := range from.Planets {
+		oldSet.add(strconv.Itoa(p.Id), p)
+	}
+	for _, p := range to.Planets {
+		newSet.add(strconv.Itoa(p.Id), p)
+	}
+	total.add(oldSet.diff("planet", newSet))
+
+	// species are in a map, so walk them in id order to keep the output stable.
+	oldSet, newSet = &diffSet{}, &diffSet{}
+	for id := 1; id <= jsondb.MAX_SPECIES; id++ {
+		key := fmt.Sprintf("SP%02d", id)
+		if sp, ok := from.Species[key]; ok {
+			oldSet.add(key, sp)
+		}
+		if sp, ok := to.Species[key]; ok {
+			newSet.add(key, sp)
+		}
+	}
+	total.add(oldSet.diff("species", newSet))
+
+	fmt.Printf("%d added, %d removed, %d changed\n", total.added, total.removed, total.changed)
+	return nil
+}
+
+// diffCount is the number of differences of each kind.
+type diffCount struct {
+	added, removed, changed int
+}
+
+func (c *diffCount) add(o diffCount) {
+	c.added, c.removed, c.changed = c.added+o.added, c.removed+o.removed, c.changed+o.changed
+}
+
+// diffSet is a set of items to compare, in the order they were added.
+// Items are compared by their JSON encoding.
+type diffSet struct {
+	keys  []string
+	items map[string][]byte
+}
+
+func (d *diffSet) add(key string, item interface{}) {
+	if d.items == nil {
+		d.items = make(map[string][]byte)
+	}
+	b, err := json.Marshal(item)
+	if err != nil {
+		// the item was just decoded from JSON, so this shouldn't happen.
+		b = []byte(err.Error())
+	}
+	if _, ok := d.items[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.items[key] = b
+}
+
+// diff prints the items that are only in d as removed, the items that are
+// only in to as added, and the items that are in both but differ as changed.
+func (d *diffSet) diff(kind string, to *diffSet) diffCount {
+	var c diffCount
+	for _, key := range d.keys {
+		if b, ok := to.items[key]; !ok {
+			fmt.Printf("- %s %s\n", kind, key)
+			c.removed++
+		} else if !bytes.Equal(b, d.items[key]) {
+			fmt.Printf("~ %s %s\n", kind, key)
+			c.changed++
+		}
+	}
+	for _, key := range to.keys {
+		if _, ok := d.items[key]; !ok {
+			fmt.Printf("+ %s %s\n", kind, key)
+			c.added++
+		}
+	}
+	return c
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Broker fans out published events to subscribers.
+// It keeps a short history so that clients can resume
+// a stream by sending the Last-Event-ID header.
+type Broker struct {
+	sync.Mutex
+	nextId  int64
+	history []*Event
+	size    int
+	subs    map[*Subscription]bool
+	closed  bool
+}
+
+// Subscription receives the events visible to a single species.
+// C is closed when the subscriber falls behind or the broker is closed.
+type Subscription struct {
+	C         chan *Event
+	SpeciesId int
+}
+
+// NewBroker returns an initialized broker that remembers the last size events.
+func NewBroker(size int) *Broker {
+	if size < 1 {
+		size = 1
+	}
+	return &Broker{
+		// seed ids from the clock so that they keep increasing across restarts.
+		nextId: time.Now().UnixNano() / int64(time.Millisecond),
+		size:   size,
+		subs:   make(map[*Subscription]bool),
+	}
+}
+
+// Close closes every subscription and rejects new ones.
+func (b *Broker) Close() {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.C)
+		delete(b.subs, sub)
+	}
+}
+
+// Publish creates a new event and sends it to every subscriber allowed to see it.
+// Subscribers that are not keeping up are dropped; they are expected to reconnect
+// and resume from the last event they received.
+func (b *Broker) Publish(eventType string, speciesId int, data interface{}) *Event {
+	b.Lock()
+	defer b.Unlock()
+	b.nextId++
+	e := &Event{
+		Id:        b.nextId,
+		Type:      eventType,
+		SpeciesId: speciesId,
+		Created:   time.Now().UTC(),
+		Data:      data,
+	}
+	if len(b.history) == b.size {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	b.history = append(b.history, e)
+	for sub := range b.subs {
+		if !e.VisibleTo(sub.SpeciesId) {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+			close(sub.C)
+			delete(b.subs, sub)
+		}
+	}
+	return e
+}
+
+// Subscribe registers a new subscription for the species.
+// If lastEventId is not zero, it also returns the events from the history
+// that were published after it and are visible to the species.
+func (b *Broker) Subscribe(speciesId int, lastEventId int64) (*Subscription, []*Event) {
+	b.Lock()
+	defer b.Unlock()
+	sub := &Subscription{C: make(chan *Event, 16), SpeciesId: speciesId}
+	if b.closed {
+		close(sub.C)
+		return sub, nil
+	}
+	b.subs[sub] = true
+	var missed []*Event
+	if lastEventId != 0 {
+		for _, e := range b.history {
+			if e.Id > lastEventId && e.VisibleTo(speciesId) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	return sub, missed
+}
+
+// Subscribers returns the number of active subscriptions.
+func (b *Broker) Subscribers() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.subs)
+}
+
+// SubscribersBySpecies returns the number of active subscriptions for each species.
+func (b *Broker) SubscribersBySpecies() map[int]int {
+	b.Lock()
+	defer b.Unlock()
+	m := make(map[int]int)
+	for sub := range b.subs {
+		m[sub.SpeciesId]++
+	}
+	return m
+}
+
+// Unsubscribe removes the subscription from the broker.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	if b.subs[sub] {
+		close(sub.C)
+		delete(b.subs, sub)
+	}
+}
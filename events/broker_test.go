@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package events
+
+import (
+	"testing"
+)
+
+// TestSpeciesFilter checks that an event for one species is never delivered
+// to another, either live or when a stream resumes from the history.
+func TestSpeciesFilter(t *testing.T) {
+	const a, b = 3, 5
+	broker := NewBroker(16)
+	subA, _ := broker.Subscribe(a, 0)
+	subB, _ := broker.Subscribe(b, 0)
+
+	first := broker.Publish(TurnPublished, 0, nil)
+	broker.Publish(OrdersReceived, a, nil)
+	broker.Publish(MessageReceived, a, nil)
+	broker.Publish(MessageReceived, b, nil)
+	_, missed := broker.Subscribe(b, first.Id) // a stream resuming after the first event
+	broker.Close()                             // closes the channels so that the loops below end
+
+	var gotA, gotB []string
+	for e := range subA.C {
+		gotA = append(gotA, e.Type)
+	}
+	for e := range subB.C {
+		if e.SpeciesId == a {
+			t.Errorf("species %d received %s for species %d", b, e.Type, a)
+		}
+		gotB = append(gotB, e.Type)
+	}
+	if want := []string{TurnPublished, OrdersReceived, MessageReceived}; !equal(gotA, want) {
+		t.Errorf("species %d: want %v, got %v", a, want, gotA)
+	}
+	if want := []string{TurnPublished, MessageReceived}; !equal(gotB, want) {
+		t.Errorf("species %d: want %v, got %v", b, want, gotB)
+	}
+
+	for _, e := range missed {
+		if e.SpeciesId == a {
+			t.Errorf("species %d resumed with %s for species %d", b, e.Type, a)
+		}
+	}
+	if len(missed) != 1 || missed[0].Type != MessageReceived {
+		t.Errorf("species %d: want 1 missed %s, got %d", b, MessageReceived, len(missed))
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
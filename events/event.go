@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package events implements a small publish/subscribe broker
+// for pushing changes to clients as server-sent events.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Event types pushed to clients.
+// Orders and messages are published for a single species;
+// the others are published for every species.
+const (
+	DataReloaded    = "data-reloaded"
+	MessageReceived = "message-received"
+	OrdersReceived  = "orders-received"
+	TurnPublished   = "turn-published"
+)
+
+type Event struct {
+	Id        int64
+	Type      string
+	SpeciesId int // zero means the event is visible to every species
+	Created   time.Time
+	Data      interface{}
+}
+
+// VisibleTo returns true if the species should receive the event.
+func (e *Event) VisibleTo(speciesId int) bool {
+	return e.SpeciesId == 0 || e.SpeciesId == speciesId
+}
+
+// WriteTo writes the event using the text/event-stream format.
+// The data is marshalled to JSON; newlines are split across data lines.
+func (e *Event) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.Marshal(e.Data)
+	if err != nil {
+		return 0, err
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("id: %d\nevent: %s\n", e.Id, e.Type))
+	for _, line := range strings.Split(string(b), "\n") {
+		sb.WriteString("data: ")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
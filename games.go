@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/mdhender/fhdb/accounts"
+	"github.com/mdhender/fhdb/audit"
+	"github.com/mdhender/fhdb/config"
+	"github.com/mdhender/fhdb/events"
+	"github.com/mdhender/fhdb/handlers"
+	"github.com/mdhender/fhdb/ports"
+	"github.com/mdhender/fhdb/store/memory"
+	"github.com/mdhender/fhdb/way"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gameIdExpr matches game ids. A game is a folder in the data directory
+// whose name matches and which holds a galaxy file.
+const gameIdExpr = `[a-zA-Z0-9][-a-zA-Z0-9_]{0,31}`
+
+var validGameId = regexp.MustCompile(`^` + gameIdExpr + `$`)
+
+// game is one game hosted by the server, with its own store,
+// player accounts, event broker, and audit log.
+type game struct {
+	id          string
+	dir         string // folder holding the game's files
+	events      *events.Broker
+	audit       *audit.Log // nil until the server opens it
+	metrics     *serverMetrics
+	reloadLock  sync.Mutex // serializes reloads
+	dsLock      sync.RWMutex
+	ds          *memory.Store
+	accts       *accounts.Accounts
+	dataModTime time.Time // modification time of the galaxy file when it was loaded
+	loadState   struct {
+		reloading bool
+		loadedAt  time.Time
+		err       error // set when the last load failed
+	}
+}
+
+// findGames returns the ids of the games in the data directory, sorted.
+// It is an error if there aren't any.
+func findGames(data string) ([]string, error) {
+	entries, err := ioutil.ReadDir(data)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() || !validGameId.MatchString(e.Name()) {
+			continue
+		} else if _, err := os.Stat(filepath.Join(data, e.Name(), "galaxy.json")); err != nil {
+			continue
+		}
+		ids = append(ids, e.Name())
+	}
+	if len(ids) == 0 {
+		if _, err := os.Stat(filepath.Join(data, "galaxy.json")); err == nil {
+			// this is the layout from before the server hosted more than one game.
+			return nil, fmt.Errorf("%s: no games found, but there is a galaxy.json; move the game's files into a folder named for the game", data)
+		}
+		return nil, fmt.Errorf("%s: no games found", data)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// gameDir returns the folder of a game named on the command line.
+// The id can be empty if there is only one game.
+func gameDir(data, id string) (string, error) {
+	ids, err := findGames(data)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		if len(ids) != 1 {
+			return "", fmt.Errorf("there are %d games (%s), so -game is required", len(ids), strings.Join(ids, ", "))
+		}
+		id = ids[0]
+	}
+	for _, gameId := range ids {
+		if gameId == id {
+			return filepath.Join(data, id), nil
+		}
+	}
+	return "", fmt.Errorf("game %q not found in %s", id, data)
+}
+
+// gameFlag adds the -game option to a command that works on one game.
+func gameFlag(fs *config.FlagSet) *string {
+	return fs.String("game", "", "game to use (can be omitted when there is only one)")
+}
+
+// loadGames finds and loads every game in the data directory.
+func (s *Server) loadGames(data string) error {
+	ids, err := findGames(data)
+	if err != nil {
+		return err
+	}
+	s.games = make(map[string]*game)
+	for _, id := range ids {
+		g := &game{id: id, dir: filepath.Join(data, id), events: events.NewBroker(256), metrics: s.metrics}
+		if err := g.reload(); err != nil {
+			return fmt.Errorf("game %s: %w", id, err)
+		}
+		s.games[id] = g
+	}
+	log.Printf("[main] loaded %d game(s): %s\n", len(ids), strings.Join(ids, ", "))
+	return nil
+}
+
+// gameList returns the games sorted by id.
+func (s *Server) gameList() []*game {
+	var list []*game
+	for _, g := range s.games {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].id < list[j].id
+	})
+	return list
+}
+
+// gameContextKey is the context key for the game named in the path.
+type gameContextKey struct{}
+
+// getGame returns the game added to the request by inGame.
+func getGame(r *http.Request) *game {
+	if g, ok := r.Context().Value(gameContextKey{}).(*game); ok {
+		return g
+	}
+	return nil
+}
+
+// inGame looks up the game named in the path and adds it to the request.
+// Authenticated requests are only allowed in the game their token is scoped to,
+// so a token for one game can't be used to read another.
+// It must run after Authenticate on routes that need a session.
+func (s *Server) inGame(h http.Handler) http.Handler {
+	return way.Mark(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g, ok := s.games[way.Param(r.Context(), "game")]
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if sess := handlers.GetSession(r); sess != nil && sess.Authenticated && sess.Game != g.id {
+			http.Error(w, "token is not for this game", http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), gameContextKey{}, g)
+		handlers.Version(h, g.store().Version).ServeHTTP(w, r.WithContext(ctx))
+	}), markInGame)
+}
+
+// requireServerWide rejects tokens that are scoped to a game.
+// Server-wide tokens are only issued by the token command.
+func (s *Server) requireServerWide(h http.Handler) http.Handler {
+	return way.Mark(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sess := handlers.GetSession(r); sess == nil || sess.Game != "" {
+			http.Error(w, "requires a server-wide token", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}), markServerWide)
+}
+
+// list the caller's games. a token is for a single game, and each game has its
+// own players, so that is the only game listed. server-wide tokens list every game.
+func (s *Server) handleGetGames() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess := handlers.GetSession(r)
+		if sess == nil || !sess.Authenticated {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		rsp := []*ports.GameResponse{}
+		for _, g := range s.gameList() {
+			if sess.Game != "" && sess.Game != g.id {
+				continue
+			}
+			ds := g.store()
+			rsp = append(rsp, &ports.GameResponse{
+				Id:         g.id,
+				Version:    ds.Version,
+				TurnNumber: ds.TurnNumber,
+				SpeciesId:  sess.SpeciesId,
+				Link:       g.route() + "/version",
+			})
+		}
+		jsonOk(w, r, rsp)
+	}
+}
+
+// route returns the prefix of the game's routes.
+func (g *game) route() string {
+	return "/api/games/" + g.id
+}
+
+// rotateAudit archives the game's audit log when the turn changes.
+func (g *game) rotateAudit(turn int) {
+	if g.audit == nil {
+		return
+	}
+	if err := g.audit.Rotate(turn); err != nil {
+		log.Printf("[audit] %s: rotate: %+v\n", g.id, err)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Names of the cookies used by browser sessions.
+// The session cookie holds the token and can't be read by scripts.
+// The CSRF cookie can be read by scripts, which must echo it in the
+// X-CSRF-Token header on any request that changes state.
+const (
+	SessionCookie = "fhdb_session"
+	CSRFCookie    = "fhdb_csrf"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+// ParseSameSite returns the SameSite mode for "strict", "lax", or "none".
+// Browsers only send Strict and Lax cookies with requests from the same site,
+// so a page on another site can only use the session cookie, even from an
+// origin allowed to send credentials by the CORS policy, when the mode is None.
+func ParseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return http.SameSiteDefaultMode, fmt.Errorf("samesite %q: must be strict, lax, or none", mode)
+}
+
+// SetSessionCookies sets the session and CSRF cookies.
+// It returns the CSRF token so that it can be sent in the response body.
+func SetSessionCookies(w http.ResponseWriter, token string, expiresAt time.Time, sameSite http.SameSite) string {
+	csrf := newCSRFToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    csrf,
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   true,
+		SameSite: sameSite,
+	})
+	return csrf
+}
+
+// ClearSessionCookies tells the browser to drop the session and CSRF cookies.
+// The mode must match the one the cookies were set with, or browsers may ignore it.
+func ClearSessionCookies(w http.ResponseWriter, sameSite http.SameSite) {
+	for _, name := range []string{SessionCookie, CSRFCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			Secure:   true,
+			HttpOnly: name == SessionCookie,
+			SameSite: sameSite,
+		})
+	}
+}
+
+// checkCSRF returns true if the request is safe or if the CSRF header
+// matches the CSRF cookie.
+func checkCSRF(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	c, err := r.Cookie(CSRFCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.Header.Get(CSRFHeader))) == 1
+}
+
+// newCSRFToken returns a random token.
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing means the system is broken
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -24,17 +24,138 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func CORS(h http.HandlerFunc) http.HandlerFunc {
+// CORSPolicy is the set of origins that may call the API from a browser.
+// Origins are a scheme and host, with an optional port, like "https://example.com".
+// A host of "*.example.com" matches every subdomain of example.com but not example.com itself,
+// and the origin "*" matches any origin.
+type CORSPolicy struct {
+	Origins       []string      // origins allowed to call the API; empty means same-origin only
+	Credentials   []string      // allowed origins that may also send cookies; "*" is never honored (see ParseSameSite)
+	Headers       []string      // request headers that preflight requests may ask for
+	ExposeHeaders []string      // response headers that scripts may read
+	MaxAge        time.Duration // how long browsers may cache a preflight response
+}
+
+// CORS adds the headers that allow the API to be called from the origins in the policy.
+// The allowed origin is echoed back rather than sent as "*", so responses vary by Origin.
+// Requests from other origins get no CORS headers at all.
+// Preflight requests are passed through so that the router can answer them
+// with the methods that are registered for the path; for allowed origins,
+// the router's Allow header is copied to Access-Control-Allow-Methods.
+func CORS(h http.HandlerFunc, p CORSPolicy) http.HandlerFunc {
+	origins, credentials := compileOrigins(p.Origins, true), compileOrigins(p.Credentials, false)
+	headers, expose := strings.Join(p.Headers, ", "), strings.Join(p.ExposeHeaders, ", ")
+	maxAge := strconv.Itoa(int(p.MaxAge.Seconds()))
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET,HEAD,POST,PUT,PATCH,DELETE,OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusNoContent)
+		if len(origins) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !matchOrigin(origins, origin) {
+			h.ServeHTTP(w, r)
 			return
 		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if matchOrigin(credentials, origin) {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			if p.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w = &preflightWriter{ResponseWriter: w}
+		} else if expose != "" {
+			w.Header().Set("Access-Control-Expose-Headers", expose)
+		}
 		h.ServeHTTP(w, r)
 	})
 }
+
+// preflightWriter copies the Allow header to Access-Control-Allow-Methods
+// when the response is written.
+type preflightWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (pw *preflightWriter) WriteHeader(code int) {
+	if !pw.wroteHeader {
+		pw.wroteHeader = true
+		if allow := pw.Header().Get("Allow"); allow != "" {
+			pw.Header().Set("Access-Control-Allow-Methods", allow)
+		}
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+func (pw *preflightWriter) Write(b []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+// originPattern is a parsed entry from an origins list.
+type originPattern struct {
+	any      bool   // matches every origin
+	scheme   string // like "https"
+	host     string // host and port; for wildcards, the suffix after the "*"
+	wildcard bool   // host matches subdomains
+}
+
+// compileOrigins parses an origins list. Entries that can't be parsed are dropped,
+// as is "*" unless allowAny is set.
+func compileOrigins(list []string, allowAny bool) []originPattern {
+	var patterns []originPattern
+	for _, s := range list {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "*" {
+			if allowAny {
+				patterns = append(patterns, originPattern{any: true})
+			}
+			continue
+		}
+		i := strings.Index(s, "://")
+		if i < 1 || i+3 == len(s) {
+			continue
+		}
+		op := originPattern{scheme: s[:i], host: s[i+3:]}
+		if strings.HasPrefix(op.host, "*.") {
+			op.host, op.wildcard = op.host[1:], true
+		}
+		patterns = append(patterns, op)
+	}
+	return patterns
+}
+
+// matchOrigin returns true if the origin matches one of the patterns.
+func matchOrigin(patterns []originPattern, origin string) bool {
+	origin = strings.ToLower(origin)
+	i := strings.Index(origin, "://")
+	if i < 1 {
+		return false
+	}
+	scheme, host := origin[:i], origin[i+3:]
+	for _, op := range patterns {
+		if op.any {
+			return true
+		} else if op.scheme != scheme {
+			continue
+		} else if op.wildcard && strings.HasSuffix(host, op.host) && len(host) > len(op.host) {
+			return true
+		} else if !op.wildcard && host == op.host {
+			return true
+		}
+	}
+	return false
+}
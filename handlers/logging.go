@@ -0,0 +1,238 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestInfo collects details about a request as it passes through the handlers.
+// Inner handlers update it (for example, Authenticate sets the species) so that
+// outer handlers like AccessLog can report on it.
+type RequestInfo struct {
+	Id        string
+	Game      string // from the token, empty for server-wide tokens
+	SpeciesId int
+	Username  string
+	Roles     []string
+	AuthError error  // set when Authenticate rejects the request
+	Summary   string // set by handlers that change state, for the audit log
+}
+
+// GetRequestInfo returns the request information attached by RequestId.
+func GetRequestInfo(r *http.Request) *RequestInfo {
+	if info, ok := r.Context().Value(fhContextKey("request-info")).(*RequestInfo); ok {
+		return info
+	}
+	return nil
+}
+
+// Summarize records a short description of the change that the request made.
+func Summarize(r *http.Request, format string, args ...interface{}) {
+	if info := GetRequestInfo(r); info != nil {
+		info.Summary = fmt.Sprintf(format, args...)
+	}
+}
+
+// withRequestInfo makes sure that the request carries a RequestInfo.
+func withRequestInfo(r *http.Request) (*http.Request, *RequestInfo) {
+	if info := GetRequestInfo(r); info != nil {
+		return r, info
+	}
+	info := &RequestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), fhContextKey("request-info"), info)), info
+}
+
+// RequestId assigns an id to every request and returns it in the X-Request-Id header.
+// When trustUpstream is set, an id sent by an upstream proxy is reused if it looks sane.
+// Otherwise clients could pick the ids that end up in the logs.
+func RequestId(h http.Handler, trustUpstream bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &RequestInfo{}
+		if trustUpstream {
+			info.Id = r.Header.Get("X-Request-Id")
+		}
+		if !isSaneRequestId(info.Id) {
+			info.Id = newRequestId()
+		}
+		w.Header().Set("X-Request-Id", info.Id)
+		ctx := context.WithValue(r.Context(), fhContextKey("request-info"), info)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AccessLog writes one JSON line per request to w.
+// When debug is set, the query and the request and response headers are included too,
+// and each request is logged to the server log as it starts and when it finishes.
+func AccessLog(h http.Handler, w io.Writer, debug bool) http.HandlerFunc {
+	var mu sync.Mutex // serialize writes so that lines don't interleave
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		r, info := withRequestInfo(r)
+		rec := &responseRecorder{ResponseWriter: rw}
+		if debug {
+			log.Printf("[debug] %s: %s %s: started, %d bytes\n", info.Id, r.Method, r.URL.Path, r.ContentLength)
+		}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if debug {
+			log.Printf("[debug] %s: %s %s: %d, %d bytes, %s\n", info.Id, r.Method, r.URL.Path, rec.status, rec.bytes, time.Since(started))
+		}
+
+		entry := accessLogEntry{
+			Time:      started.UTC().Format(time.RFC3339Nano),
+			RequestId: info.Id,
+			Remote:    r.RemoteAddr,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    rec.status,
+			Bytes:     rec.bytes,
+			LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+			SpeciesId: info.SpeciesId,
+		}
+		if debug {
+			entry.Query = r.URL.RawQuery
+			entry.RequestHeaders = redactHeaders(r.Header)
+			entry.ResponseHeaders = redactHeaders(rec.Header())
+		}
+		b, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("[access] %+v\n", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err = w.Write(append(b, '\n')); err != nil {
+			log.Printf("[access] %+v\n", err)
+		}
+	})
+}
+
+// Observe calls fn after every request with the response status and elapsed time.
+func Observe(h http.Handler, fn func(r *http.Request, info *RequestInfo, status int, elapsed time.Duration)) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		r, info := withRequestInfo(r)
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		fn(r, info, rec.status, time.Since(started))
+	})
+}
+
+type accessLogEntry struct {
+	Time            string              `json:"time"`
+	RequestId       string              `json:"request_id"`
+	Remote          string              `json:"remote"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query,omitempty"`
+	Status          int                 `json:"status"`
+	Bytes           int64               `json:"bytes"`
+	LatencyMs       float64             `json:"latency_ms"`
+	SpeciesId       int                 `json:"species_id,omitempty"`
+	RequestHeaders  map[string][]string `json:"request_headers,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+}
+
+// responseRecorder captures the status and size of a response.
+// It implements http.Flusher so that event streams still work.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rr *responseRecorder) Flush() {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func isSaneRequestId(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, ch := range id {
+		if !(('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') || ch == '-' || ch == '_' || ch == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		// fall back to the clock rather than failing the request
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// redactHeaders returns a copy of the headers with credentials removed.
+func redactHeaders(h http.Header) map[string][]string {
+	m := make(map[string][]string)
+	for k, v := range h {
+		switch strings.ToLower(k) {
+		case "authorization", "cookie", "set-cookie":
+			m[k] = []string{"[redacted]"}
+		default:
+			m[k] = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter counts events per key over a sliding window.
+type RateLimiter struct {
+	sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+}
+
+// NewRateLimiter returns a limiter that allows limit events per key in each window.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// Allow returns true if the key is under the limit, and records an event for it.
+// Checking and recording happen together so that concurrent callers can't all
+// get in under the limit. Callers whose attempt shouldn't count call Refund.
+// When the key is over the limit, Allow also returns how long until the
+// oldest event leaves the window.
+func (rl *RateLimiter) Allow(key string) (bool, time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+	now := time.Now()
+	hits := rl.prune(key, now)
+	if len(hits) < rl.limit {
+		rl.hits[key] = append(hits, now)
+		return true, 0
+	}
+	return false, hits[0].Add(rl.window).Sub(now)
+}
+
+// Refund removes the most recent event for the key.
+func (rl *RateLimiter) Refund(key string) {
+	rl.Lock()
+	defer rl.Unlock()
+	hits := rl.prune(key, time.Now())
+	if len(hits) <= 1 {
+		delete(rl.hits, key)
+		return
+	}
+	rl.hits[key] = hits[:len(hits)-1]
+}
+
+// Prune drops every key whose events have all left the window.
+// Keys are otherwise only pruned when they are used again,
+// so callers should run it periodically.
+func (rl *RateLimiter) Prune() {
+	rl.Lock()
+	defer rl.Unlock()
+	now := time.Now()
+	for key := range rl.hits {
+		rl.prune(key, now)
+	}
+}
+
+// prune drops events that have left the window. Must be called with the lock held.
+func (rl *RateLimiter) prune(key string, now time.Time) []time.Time {
+	hits := rl.hits[key]
+	for len(hits) != 0 && !now.Before(hits[0].Add(rl.window)) {
+		hits = hits[1:]
+	}
+	if len(hits) == 0 {
+		delete(rl.hits, key)
+		return nil
+	}
+	rl.hits[key] = hits
+	return hits
+}
+
+// RemoteHost returns the host part of the request's remote address.
+func RemoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
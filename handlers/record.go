@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecordedRequest is a single line in a recording of API traffic.
+type RecordedRequest struct {
+	Time       string           `json:"time"`
+	Method     string           `json:"method"`
+	Path       string           `json:"path"`           // includes the query string
+	Body       string           `json:"body,omitempty"` // request body, with passwords redacted
+	Skip       string           `json:"skip,omitempty"` // why the request can't be replayed, if it can't
+	Subject    *RecordedSubject `json:"subject,omitempty"`
+	Status     int              `json:"status"`
+	BodySHA256 string           `json:"body_sha256"` // see ResponseHash
+}
+
+// RecordedSubject is the authenticated caller of a recorded request.
+type RecordedSubject struct {
+	Game      string   `json:"game,omitempty"`
+	SpeciesId int      `json:"species_id"`
+	Username  string   `json:"username,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// maxRecordedBody is the largest request body that is recorded.
+// It matches the largest body any handler accepts.
+const maxRecordedBody = 64 * 1024
+
+// volatileFields are the response fields that change every time a request
+// is made, such as timestamps and freshly signed tokens.
+// ResponseHash leaves them out so that a replay can match the recording.
+var volatileFields = map[string]bool{
+	"csrf_token":  true,
+	"expires_at":  true,
+	"first_seen":  true,
+	"issued_at":   true,
+	"last_seen":   true,
+	"loaded_at":   true,
+	"received_at": true,
+	"remote":      true,
+	"sent_at":     true,
+	"token":       true,
+	"token_id":    true,
+}
+
+// Record writes a RecordedRequest line to w for every request.
+// Event streams are not recorded since they never complete.
+func Record(h http.Handler, w io.Writer) http.HandlerFunc {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		r, info := withRequestInfo(r)
+		body, skip := recordBody(r)
+		rec := &bodyRecorder{responseRecorder: responseRecorder{ResponseWriter: rw}}
+		h.ServeHTTP(rec, r)
+		if strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream") {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		entry := RecordedRequest{
+			Time:       time.Now().UTC().Format(time.RFC3339),
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Body:       body,
+			Skip:       skip,
+			Status:     rec.status,
+			BodySHA256: ResponseHash(rec.body.Bytes()),
+		}
+		if info.SpeciesId != 0 {
+			entry.Subject = &RecordedSubject{
+				Game:      info.Game,
+				SpeciesId: info.SpeciesId,
+				Username:  info.Username,
+				Roles:     info.Roles,
+			}
+		}
+		b, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("[record] %+v\n", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err = w.Write(append(b, '\n')); err != nil {
+			log.Printf("[record] %+v\n", err)
+		}
+	})
+}
+
+// ResponseHash returns the SHA-256 of a response body as a hex string.
+// JSON bodies are hashed without their volatile fields.
+func ResponseHash(body []byte) string {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&v); err == nil && !d.More() {
+		if b, err := json.Marshal(dropVolatile(v)); err == nil {
+			body = b
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// dropVolatile removes the volatile fields from every object in v.
+func dropVolatile(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if volatileFields[k] {
+				delete(v, k)
+			} else {
+				v[k] = dropVolatile(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = dropVolatile(e)
+		}
+	}
+	return v
+}
+
+// recordBody reads the request body and puts it back for the handler.
+// Passwords are redacted, which means the request can't be replayed,
+// so it returns the reason to skip the request as well.
+func recordBody(r *http.Request) (body, skip string) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", ""
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRecordedBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	if err != nil {
+		return "", "body could not be read"
+	} else if len(b) > maxRecordedBody {
+		return "", "body too large"
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(b, &fields) == nil {
+		if _, ok := fields["password"]; ok {
+			fields["password"], _ = json.Marshal("[redacted]")
+			if rb, err := json.Marshal(fields); err == nil {
+				return string(rb), "password redacted"
+			}
+			return "", "password redacted"
+		}
+	}
+	return string(b), ""
+}
+
+// bodyRecorder keeps a copy of the response body.
+// Event streams are not kept since they never complete.
+type bodyRecorder struct {
+	responseRecorder
+	body bytes.Buffer
+}
+
+func (br *bodyRecorder) Write(b []byte) (int, error) {
+	if !strings.HasPrefix(br.Header().Get("Content-Type"), "text/event-stream") {
+		_, _ = br.body.Write(b)
+	}
+	return br.responseRecorder.Write(b)
+}
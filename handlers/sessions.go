@@ -24,8 +24,13 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"github.com/mdhender/fhdb/jwt"
+	"github.com/mdhender/fhdb/policy"
 	"net/http"
+	"sort"
+	"sync"
+	"time"
 )
 
 // fhContextKey is the context key type for storing parameters in context.Context.
@@ -33,8 +38,16 @@ type fhContextKey string
 
 type Session struct {
 	Authenticated bool
+	Game          string // the game the token is scoped to, empty for server-wide tokens
 	SpeciesId     int
+	Username      string
+	Email         string
 	Roles         map[string]bool
+	TokenId       string    // the jti claim, empty for tokens issued before ids were added
+	IssuedAt      time.Time // when the token was issued
+	ExpiresAt     time.Time // when the token expires
+	FromCookie    bool      // true if the token came from the session cookie
+	Principal     policy.Principal
 }
 
 func GetSession(r *http.Request) *Session {
@@ -44,25 +57,56 @@ func GetSession(r *http.Request) *Session {
 	return nil
 }
 
+// Authenticate accepts a bearer token or, if there is no Authorization header,
+// the session cookie. Requests authenticated by the cookie must pass the CSRF
+// check before they can change state.
 func Authenticate(h http.HandlerFunc, f jwt.Factory) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromCookie := false
 		j, err := jwt.FromHeader(r)
+		if err == jwt.ErrMissingAuthHeader {
+			if _, cerr := r.Cookie(SessionCookie); cerr == nil {
+				fromCookie = true
+				j, err = jwt.FromCookie(r, SessionCookie)
+			}
+		}
 		if err == nil {
 			err = f.Validate(j)
 		}
 		if err != nil {
+			if info := GetRequestInfo(r); info != nil {
+				info.AuthError = err
+			}
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
+		if fromCookie && !checkCSRF(r) {
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
 
 		s := &Session{
 			Authenticated: true,
+			Game:          j.Data().Game,
 			SpeciesId:     j.Data().Id,
+			Username:      j.Data().Username,
+			Email:         j.Data().Email,
 			Roles:         make(map[string]bool),
+			TokenId:       j.Id(),
+			IssuedAt:      j.IssuedAt(),
+			ExpiresAt:     j.ExpiresAt(),
+			FromCookie:    fromCookie,
 		}
 		for _, rr := range j.Data().Roles {
 			s.Roles[rr] = true
 		}
+		s.Principal = policy.FromRoles(s.SpeciesId, j.Data().Roles)
+		if info := GetRequestInfo(r); info != nil {
+			info.Game = s.Game
+			info.SpeciesId = s.SpeciesId
+			info.Username = j.Data().Username
+			info.Roles = j.Data().Roles
+		}
 
 		// valid session, so inject ourselves into the context.
 		ctx := context.WithValue(r.Context(), fhContextKey("session"), s)
@@ -71,3 +115,114 @@ func Authenticate(h http.HandlerFunc, f jwt.Factory) http.HandlerFunc {
 		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RequireScope rejects requests from sessions that don't hold the scope.
+// It must run after Authenticate.
+func RequireScope(h http.HandlerFunc, scope policy.Scope) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := GetSession(r)
+		if sess == nil || !sess.Authenticated {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if err := policy.Check(sess.Principal, scope, sess.SpeciesId, nil); err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// SessionTracker remembers the tokens that have been used recently.
+// Entries are dropped once their token expires.
+type SessionTracker struct {
+	sync.Mutex
+	seen map[string]*TrackedSession
+}
+
+type TrackedSession struct {
+	TokenId   string
+	Game      string
+	SpeciesId int
+	Username  string
+	Roles     []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Remote    string
+	Requests  int
+}
+
+func NewSessionTracker() *SessionTracker {
+	return &SessionTracker{seen: make(map[string]*TrackedSession)}
+}
+
+// Track records the session for every request.
+// It must run after Authenticate.
+func (t *SessionTracker) Track(h http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sess := GetSession(r); sess != nil && sess.Authenticated {
+			t.touch(sess, r.RemoteAddr)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Forget removes the token from the tracker, for example after it is revoked.
+func (t *SessionTracker) Forget(tokenId string) {
+	if tokenId == "" {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	delete(t.seen, tokenId)
+}
+
+// Sessions returns the sessions with unexpired tokens, most recently seen first.
+func (t *SessionTracker) Sessions() []TrackedSession {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	var list []TrackedSession
+	for key, ts := range t.seen {
+		if !ts.ExpiresAt.After(now) {
+			delete(t.seen, key)
+			continue
+		}
+		list = append(list, *ts)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+func (t *SessionTracker) touch(sess *Session, remote string) {
+	// the payload of a token doesn't change, so these fields identify older tokens without an id
+	key := sess.TokenId
+	if key == "" {
+		key = fmt.Sprintf("%s/%d/%s/%d", sess.Game, sess.SpeciesId, sess.Username, sess.IssuedAt.Unix())
+	}
+	now := time.Now().UTC()
+	t.Lock()
+	defer t.Unlock()
+	ts, ok := t.seen[key]
+	if !ok {
+		ts = &TrackedSession{
+			TokenId:   sess.TokenId,
+			Game:      sess.Game,
+			SpeciesId: sess.SpeciesId,
+			Username:  sess.Username,
+			IssuedAt:  sess.IssuedAt,
+			ExpiresAt: sess.ExpiresAt,
+			FirstSeen: now,
+		}
+		for role := range sess.Roles {
+			ts.Roles = append(ts.Roles, role)
+		}
+		sort.Strings(ts.Roles)
+		t.seen[key] = ts
+	}
+	ts.LastSeen, ts.Remote = now, remote
+	ts.Requests++
+}
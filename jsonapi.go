@@ -42,6 +42,17 @@ func jsonOk(w http.ResponseWriter, r *http.Request, data interface{}) {
 	w.Header().Set("Content-Type", "application/vnd.api+json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(ok); err != nil {
-		log.Printf("%s: error writing response: %+v\n", err)
+		log.Printf("%s: error writing response: %+v\n", r.URL.Path, err)
+	}
+}
+
+// jsonStatus writes a plain JSON response with the given status.
+// It is used for endpoints like health checks that aren't part of the api.
+func jsonStatus(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("error writing response: %+v\n", err)
 	}
 }
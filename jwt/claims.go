@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Claims are the registered claims that Validate checks.
+// An empty Issuer or Audience is not checked.
+// Leeway allows for clock skew between the issuer and the server.
+type Claims struct {
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+// CheckClaims returns an error if the token isn't valid at the given time.
+// It does not check the signature.
+func (j *JWT) CheckClaims(c Claims, now time.Time) error {
+	if j == nil {
+		return ErrNotJWT
+	} else if j.h.Algorithm != j.p.Private.Algorithm || j.h.TokenType != j.p.Private.TokenType {
+		return ErrUnauthorized // header was changed after signing
+	}
+
+	if j.p.ExpirationTime == 0 {
+		return fmt.Errorf("exp: %w", ErrMissingClaim)
+	} else if !now.Add(-c.Leeway).Before(time.Unix(j.p.ExpirationTime, 0)) {
+		return ErrExpired
+	}
+	if j.p.NotBefore != 0 && now.Add(c.Leeway).Before(time.Unix(j.p.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	if j.p.IssuedAt == 0 {
+		return fmt.Errorf("iat: %w", ErrMissingClaim)
+	} else if now.Add(c.Leeway).Before(time.Unix(j.p.IssuedAt, 0)) {
+		return ErrIssuedInFuture
+	}
+	if c.Issuer != "" && j.p.Issuer != c.Issuer {
+		return ErrBadIssuer
+	}
+	if c.Audience != "" && !j.p.Audience.Contains(c.Audience) {
+		return ErrBadAudience
+	}
+	return nil
+}
+
+// audience is the aud claim.
+// It may be a single string or an array of strings.
+type audience []string
+
+// Contains reports whether the audience includes the value.
+func (a audience) Contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+// A single audience is written as a string.
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
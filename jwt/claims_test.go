@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckClaims(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	at := func(d time.Duration) int64 {
+		return now.Add(d).Unix()
+	}
+	const leeway = time.Minute
+	valid := Claims{Issuer: "fhdb", Audience: "players", Leeway: leeway}
+
+	for _, tc := range []struct {
+		name   string
+		claims Claims
+		exp    int64
+		nbf    int64
+		iat    int64
+		iss    string
+		aud    string // raw json, empty for no aud claim
+		want   error
+	}{
+		{name: "valid", claims: valid, exp: at(time.Hour), iat: at(0), iss: "fhdb", aud: `"players"`},
+		{name: "no claims configured", exp: at(time.Hour), iat: at(0)},
+
+		{name: "exp missing", iat: at(0), want: ErrMissingClaim},
+		{name: "exp now", exp: at(0), iat: at(-time.Hour), want: ErrExpired},
+		{name: "exp past", exp: at(-time.Second), iat: at(-time.Hour), want: ErrExpired},
+		{name: "exp future", exp: at(time.Second), iat: at(-time.Hour)},
+		{name: "exp at leeway", claims: Claims{Leeway: leeway}, exp: at(-leeway), iat: at(-time.Hour), want: ErrExpired},
+		{name: "exp inside leeway", claims: Claims{Leeway: leeway}, exp: at(-leeway + time.Second), iat: at(-time.Hour)},
+
+		{name: "nbf past", exp: at(time.Hour), nbf: at(-time.Second), iat: at(-time.Hour)},
+		{name: "nbf now", exp: at(time.Hour), nbf: at(0), iat: at(-time.Hour)},
+		{name: "nbf future", exp: at(time.Hour), nbf: at(time.Second), iat: at(-time.Hour), want: ErrNotYetValid},
+		{name: "nbf at leeway", claims: Claims{Leeway: leeway}, exp: at(time.Hour), nbf: at(leeway), iat: at(-time.Hour)},
+		{name: "nbf beyond leeway", claims: Claims{Leeway: leeway}, exp: at(time.Hour), nbf: at(leeway + time.Second), iat: at(-time.Hour), want: ErrNotYetValid},
+
+		{name: "iat missing", exp: at(time.Hour), want: ErrMissingClaim},
+		{name: "iat now", exp: at(time.Hour), iat: at(0)},
+		{name: "iat future", exp: at(time.Hour), iat: at(time.Second), want: ErrIssuedInFuture},
+		{name: "iat at leeway", claims: Claims{Leeway: leeway}, exp: at(time.Hour), iat: at(leeway)},
+		{name: "iat beyond leeway", claims: Claims{Leeway: leeway}, exp: at(time.Hour), iat: at(leeway + time.Second), want: ErrIssuedInFuture},
+
+		{name: "iss missing", claims: Claims{Issuer: "fhdb"}, exp: at(time.Hour), iat: at(0), want: ErrBadIssuer},
+		{name: "iss wrong", claims: Claims{Issuer: "fhdb"}, exp: at(time.Hour), iat: at(0), iss: "other", want: ErrBadIssuer},
+		{name: "iss not checked", exp: at(time.Hour), iat: at(0), iss: "other"},
+
+		{name: "aud string", claims: Claims{Audience: "players"}, exp: at(time.Hour), iat: at(0), aud: `"players"`},
+		{name: "aud string wrong", claims: Claims{Audience: "players"}, exp: at(time.Hour), iat: at(0), aud: `"admins"`, want: ErrBadAudience},
+		{name: "aud array", claims: Claims{Audience: "players"}, exp: at(time.Hour), iat: at(0), aud: `["admins","players"]`},
+		{name: "aud array wrong", claims: Claims{Audience: "players"}, exp: at(time.Hour), iat: at(0), aud: `["admins","gms"]`, want: ErrBadAudience},
+		{name: "aud empty array", claims: Claims{Audience: "players"}, exp: at(time.Hour), iat: at(0), aud: `[]`, want: ErrBadAudience},
+		{name: "aud missing", claims: Claims{Audience: "players"}, exp: at(time.Hour), iat: at(0), want: ErrBadAudience},
+		{name: "aud not checked", exp: at(time.Hour), iat: at(0), aud: `"admins"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			j := newClaimsToken(t, tc.exp, tc.nbf, tc.iat, tc.iss, tc.aud)
+			if err := j.CheckClaims(tc.claims, now); !errors.Is(err, tc.want) {
+				t.Errorf("CheckClaims: want %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestCheckClaimsChangedHeader(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	j := newClaimsToken(t, now.Add(time.Hour).Unix(), 0, now.Unix(), "", "")
+	j.h.Algorithm = "none"
+	if err := j.CheckClaims(Claims{}, now); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("CheckClaims: want %v, got %v", ErrUnauthorized, err)
+	}
+	if err := (*JWT)(nil).CheckClaims(Claims{}, now); !errors.Is(err, ErrNotJWT) {
+		t.Errorf("CheckClaims: want %v, got %v", ErrNotJWT, err)
+	}
+}
+
+// newClaimsToken returns an unsigned token with the registered claims.
+// The aud claim is decoded from JSON so that both of its forms are exercised.
+func newClaimsToken(t *testing.T, exp, nbf, iat int64, iss, aud string) *JWT {
+	t.Helper()
+	var j JWT
+	j.h.Algorithm, j.h.TokenType = "HS256", "JWT"
+	j.p.Private.Algorithm, j.p.Private.TokenType = j.h.Algorithm, j.h.TokenType
+	j.p.ExpirationTime, j.p.NotBefore, j.p.IssuedAt = exp, nbf, iat
+	j.p.Issuer = iss
+	if aud != "" {
+		if err := json.Unmarshal([]byte(aud), &j.p.Audience); err != nil {
+			t.Fatalf("aud %s: %v", aud, err)
+		}
+	}
+	return &j
+}
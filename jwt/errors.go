@@ -24,11 +24,20 @@ package jwt
 
 import "errors"
 
+var ErrBadAudience = errors.New("bad audience")
 var ErrBadFactory = errors.New("bad factory")
+var ErrBadIssuer = errors.New("bad issuer")
 var ErrBadRequest = errors.New("bad request")
 var ErrExpired = errors.New("expired")
+var ErrIssuedInFuture = errors.New("issued in the future")
+var ErrKeyRetired = errors.New("key retired")
 var ErrMissingAuthHeader = errors.New("missing auth header")
+var ErrMissingClaim = errors.New("missing claim")
+var ErrMissingCookie = errors.New("missing cookie")
 var ErrMissingSigner = errors.New("missing signer")
 var ErrNotBearer = errors.New("not a bearer token")
 var ErrNotJWT = errors.New("not a jwt")
+var ErrNotYetValid = errors.New("not yet valid")
+var ErrRevoked = errors.New("revoked")
 var ErrUnauthorized = errors.New("unauthorized")
+var ErrUnknownKey = errors.New("unknown key")
@@ -23,42 +23,113 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"time"
 )
 
 // NewFactory returns an initialized factory.
-// The signer is used to sign the generated tokens.
+// The secret is used to sign the generated tokens.
+// The tokens don't have a key id, so the secret can't be rotated.
 func NewFactory(secret string) Factory {
-	return Factory{valid: true, s: HS256Signer([]byte(secret))}
+	kr := NewKeyring()
+	h := HS256Signer([]byte(secret))
+	_ = kr.Add(&Key{Signer: h, Verifier: h})
+	return NewKeyringFactory(kr)
 }
 
-// Validate will return an error if the JWT is not properly signed.
+// NewKeyringFactory returns an initialized factory that signs
+// with the active key in the keyring.
+func NewKeyringFactory(kr *Keyring) Factory {
+	return Factory{valid: true, keys: kr}
+}
+
+// WithRevocations returns a copy of the factory that rejects revoked tokens.
+func (f Factory) WithRevocations(rl *RevocationList) Factory {
+	f.revoked = rl
+	return f
+}
+
+// WithClaims returns a copy of the factory that sets the issuer and audience
+// on new tokens and checks the registered claims when validating.
+func (f Factory) WithClaims(c Claims) Factory {
+	f.claims = c
+	return f
+}
+
+// Claims returns the claims that Validate checks.
+func (f *Factory) Claims() Claims {
+	return f.claims
+}
+
+// IsRevoked returns true if the token has been revoked.
+func (f *Factory) IsRevoked(j *JWT) bool {
+	return f.revoked.IsRevoked(j.p.JWTID)
+}
+
+// Validate will return an error if the JWT is not properly signed,
+// if the claims aren't valid now, or if it has been revoked.
 func (f *Factory) Validate(j *JWT) error {
+	if err := f.Verify(j); err != nil {
+		return err
+	}
+	if err := j.CheckClaims(f.claims, time.Now()); err != nil {
+		return err
+	}
+	if f.IsRevoked(j) {
+		return ErrRevoked
+	}
+	return nil // valid signature and claims
+}
+
+// Verify will return an error if the JWT is not properly signed
+// by a key that can still verify tokens. It doesn't check the claims.
+func (f *Factory) Verify(j *JWT) error {
 	if !f.valid {
 		return ErrBadFactory
 	}
-	expectedSignature, err := f.s.Sign([]byte(j.h.b64 + "." + j.p.b64))
+	key, err := f.keys.Lookup(j.h.KeyID, time.Now())
 	if err != nil {
 		return err
+	} else if key.Verifier.Algorithm() != j.h.Algorithm {
+		return ErrUnauthorized // never let the token pick the algorithm
 	}
-
-	if j.isSigned = j.s == encode(expectedSignature); !j.isSigned {
+	sig, err := decode(j.s)
+	if err != nil {
 		return ErrUnauthorized
 	}
-
-	return nil // valid signature
+	if err = key.Verifier.Verify([]byte(j.h.b64+"."+j.p.b64), sig); err != nil {
+		return err
+	}
+	j.isSigned = true
+	return nil
 }
 
-func (f *Factory) NewToken(ttl time.Duration, id int, username, email string, roles ...string) string {
+// NewToken returns a token signed with the active key.
+// The token is scoped to the game unless game is empty.
+// It returns an error if the factory has no active key.
+func (f *Factory) NewToken(ttl time.Duration, game string, id int, username, email string, roles ...string) (string, error) {
+	key, err := f.keys.Active()
+	if err != nil {
+		return "", err
+	}
+
 	var j JWT
 
 	j.h.TokenType = "JWT"
-	j.h.Algorithm = f.s.Algorithm()
+	j.h.Algorithm = key.Signer.Algorithm()
+	j.h.KeyID = key.Id
+	j.p.Issuer = f.claims.Issuer
+	if f.claims.Audience != "" {
+		j.p.Audience = audience{f.claims.Audience}
+	}
 	j.p.IssuedAt = time.Now().Unix()
+	j.p.JWTID = newTokenId()
 	j.p.ExpirationTime = time.Now().Add(ttl).Unix()
 	j.p.Private.TokenType = j.h.TokenType
 	j.p.Private.Algorithm = j.h.Algorithm
+	j.p.Private.Game = game
 	j.p.Private.Id = id
 	j.p.Private.Username = username
 	j.p.Private.Email = email
@@ -70,18 +141,31 @@ func (f *Factory) NewToken(ttl time.Duration, id int, username, email string, ro
 	if p, err := json.MarshalIndent(j.p, "  ", "  "); err == nil {
 		j.p.b64 = encode(p)
 	}
-	if rawSignature, err := f.s.Sign([]byte(j.h.b64 + "." + j.p.b64)); err == nil {
-		j.s = encode(rawSignature)
+	rawSignature, err := key.Signer.Sign([]byte(j.h.b64 + "." + j.p.b64))
+	if err != nil {
+		return "", err
 	}
+	j.s = encode(rawSignature)
 
-	return j.h.b64 + "." + j.p.b64 + "." + j.s
+	return j.h.b64 + "." + j.p.b64 + "." + j.s, nil
 }
 
-// Consider setting up factory to use only one algorithm.
-// If we do, can it still use a key id from the header?
-// You should create a new factory when you rotate keys!
+// Factory creates and validates tokens.
+// Keys are rotated by replacing the contents of the keyring.
 type Factory struct {
 	valid     bool
-	s         Signer
+	keys      *Keyring
 	tokenType string
+	revoked   *RevocationList
+	claims    Claims
+}
+
+// newTokenId returns a random token id.
+func newTokenId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing means the system is broken, and signing would be unsafe anyway.
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
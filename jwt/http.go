@@ -42,8 +42,21 @@ func FromHeader(r *http.Request) (*JWT, error) {
 	if authType != "Bearer" {
 		return nil, ErrNotBearer
 	}
+	return Parse(authToken)
+}
+
+// pull the token from a cookie.
+func FromCookie(r *http.Request, name string) (*JWT, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return nil, ErrMissingCookie
+	}
+	return Parse(c.Value)
+}
 
-	sections := strings.Split(authToken, ".")
+// Parse decodes a token without checking the signature or claims.
+func Parse(token string) (*JWT, error) {
+	sections := strings.Split(token, ".")
 	if len(sections) != 3 || len(sections[0]) == 0 || len(sections[1]) == 0 || len(sections[2]) == 0 {
 		return nil, ErrNotJWT
 	}
@@ -30,6 +30,7 @@ import (
 
 func (j *JWT) Data() Data {
 	return Data{
+		Game:     j.p.Private.Game,
 		Id:       j.p.Private.Id,
 		Username: j.p.Private.Username,
 		Email:    j.p.Private.Email,
@@ -37,31 +38,29 @@ func (j *JWT) Data() Data {
 	}
 }
 
+// ExpiresAt returns the expiration time of the token.
+func (j *JWT) ExpiresAt() time.Time {
+	return time.Unix(j.p.ExpirationTime, 0).UTC()
+}
+
+// Id returns the token id (the jti claim).
+// Tokens created before ids were added return an empty string.
+func (j *JWT) Id() string {
+	return j.p.JWTID
+}
+
+// IssuedAt returns the time the token was issued.
+func (j *JWT) IssuedAt() time.Time {
+	return time.Unix(j.p.IssuedAt, 0).UTC()
+}
+
+// IsValid returns true if the signature has been verified
+// and the claims are valid now.
 func (j *JWT) IsValid() bool {
-	now := time.Now().UTC()
-	if j == nil {
-		//log.Printf("jwt is nil\n")
-		return false
-	} else if !j.isSigned || j.h.Algorithm != j.p.Private.Algorithm || j.h.TokenType != j.p.Private.TokenType {
-		//log.Printf("alg %q typ %q signed %v borked\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	} else if j.p.NotBefore != 0 && !now.Before(time.Unix(j.p.NotBefore, 0)) {
-		//log.Printf("alg %q typ %q signed %v !now.Before(notBefore)\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	} else if j.p.IssuedAt == 0 {
-		//log.Printf("alg %q typ %q signed %v no issue timestamp\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	} else if !now.After(time.Unix(j.p.IssuedAt, 0)) {
-		//log.Printf("alg %q typ %q signed %v !now.After(issuedAt) %s %s\n", j.h.Algorithm, j.h.TokenType, j.isSigned, now.Format("2006-01-02T15:04:05.99999999Z"), time.Unix(j.p.IssuedAt, 0).Format("2006-01-02T15:04:05.99999999Z"))
-		return false
-	} else if j.p.ExpirationTime == 0 {
-		//log.Printf("alg %q typ %q signed %v no expiration timestamp\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	} else if !time.Unix(j.p.ExpirationTime, 0).After(now) {
-		//log.Printf("alg %q typ %q signed %v !expiresAt.After(now)\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
+	if j == nil || !j.isSigned {
 		return false
 	}
-	return true
+	return j.CheckClaims(Claims{}, time.Now()) == nil
 }
 
 type JWT struct {
@@ -69,7 +68,7 @@ type JWT struct {
 		Algorithm   string `json:"alg,omitempty"` // message authentication code algorithm
 		TokenType   string `json:"typ,omitempty"`
 		ContentType string `json:"cty,omitempty"`
-		KeyID       string `json:"kid,omitempty"` // optional identifier used to sign. doesn't work.
+		KeyID       string `json:"kid,omitempty"` // optional identifier of the key used to sign
 		b64         string // header marshalled to JSON and then base-64 encoded
 	}
 	p struct {
@@ -81,7 +80,7 @@ type JWT struct {
 		// Each principal intended to process the JWT must identify itself with a value in the audience claim.
 		// If the principal processing the claim does not identify itself with a value in the aud claim when this claim is present,
 		// then the JWT must be rejected.
-		Audience []string `json:"aud,omitempty"`
+		Audience audience `json:"aud,omitempty"`
 		// The expiration time on and after which the JWT must not be accepted for processing.
 		// The value must be a NumericDate:[9] either an integer or decimal, representing seconds past 1970-01-01 00:00:00Z.
 		ExpirationTime int64 `json:"exp,omitempty"`
@@ -97,6 +96,7 @@ type JWT struct {
 		Private struct {
 			Algorithm string   `json:"alg"`
 			TokenType string   `json:"typ"`
+			Game      string   `json:"game,omitempty"` // the only game the token can be used for
 			Id        int      `json:"id,omitempty"`
 			Username  string   `json:"username,omitempty"`
 			Email     string   `json:"email,omitempty"`
@@ -109,6 +109,7 @@ type JWT struct {
 }
 
 type Data struct {
+	Game     string // empty for tokens that aren't scoped to a game
 	Id       int
 	Username string
 	Email    string
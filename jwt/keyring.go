@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Keyring holds the named keys used to sign and verify tokens.
+// New tokens are signed with the active key and carry its id in the kid header.
+// Tokens are verified with the key named by their kid, so keys can be rotated
+// without invalidating tokens that are already out there.
+type Keyring struct {
+	sync.RWMutex
+	active string
+	keys   map[string]*Key
+}
+
+// Key is a named signer and verifier.
+// Signer is nil when only the public key is known.
+// A retired key is no longer used for signing but still verifies
+// tokens until VerifyUntil. The zero time means it never stops verifying.
+type Key struct {
+	Id          string
+	Signer      Signer
+	Verifier    Verifier
+	VerifyUntil time.Time
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*Key)}
+}
+
+// Add adds a key to the keyring. The first key added becomes the active key.
+func (kr *Keyring) Add(k *Key) error {
+	kr.Lock()
+	defer kr.Unlock()
+	if _, ok := kr.keys[k.Id]; ok {
+		return fmt.Errorf("duplicate key id %q", k.Id)
+	}
+	kr.keys[k.Id] = k
+	if len(kr.keys) == 1 {
+		kr.active = k.Id
+	}
+	return nil
+}
+
+// SetActive changes the key used for signing new tokens.
+func (kr *Keyring) SetActive(kid string) error {
+	kr.Lock()
+	defer kr.Unlock()
+	if _, ok := kr.keys[kid]; !ok {
+		return fmt.Errorf("active key %q: %w", kid, ErrUnknownKey)
+	}
+	kr.active = kid
+	return nil
+}
+
+// Replace swaps the contents of the keyring with another.
+// Factories share the keyring, so this rotates keys for all of them.
+func (kr *Keyring) Replace(other *Keyring) {
+	other.RLock()
+	active, keys := other.active, other.keys
+	other.RUnlock()
+	kr.Lock()
+	defer kr.Unlock()
+	kr.active, kr.keys = active, keys
+}
+
+// Active returns the key used to sign new tokens.
+func (kr *Keyring) Active() (*Key, error) {
+	kr.RLock()
+	defer kr.RUnlock()
+	k, ok := kr.keys[kr.active]
+	if !ok || k.Signer == nil {
+		return nil, ErrMissingSigner
+	}
+	return k, nil
+}
+
+// Lookup returns the key that verifies tokens with the kid.
+// Keys past their cutoff are rejected.
+func (kr *Keyring) Lookup(kid string, now time.Time) (*Key, error) {
+	kr.RLock()
+	defer kr.RUnlock()
+	k, ok := kr.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	} else if !k.VerifyUntil.IsZero() && !now.Before(k.VerifyUntil) {
+		return nil, ErrKeyRetired
+	}
+	return k, nil
+}
+
+// LoadKeyring reads a key file. The file is a JSON object like
+//
+//	{
+//	  "active": "2021-07",
+//	  "keys": [
+//	    {"kid": "2021-07", "alg": "ES256", "pem": "es256-2021-07.pem"},
+//	    {"kid": "2021-04", "alg": "HS256", "secret": "...", "verify_until": "2021-08-01T00:00:00Z"}
+//	  ]
+//	}
+//
+// HS256 keys use a secret. RS256, ES256, and EdDSA keys are read from PEM files,
+// relative to the key file; a public key can verify tokens but not sign them.
+// A key with an empty kid verifies tokens that were issued without one.
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Active string `json:"active"`
+		Keys   []struct {
+			Id          string    `json:"kid"`
+			Algorithm   string    `json:"alg"`
+			Secret      string    `json:"secret"`
+			PEM         string    `json:"pem"`
+			VerifyUntil time.Time `json:"verify_until"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	kr := NewKeyring()
+	for _, k := range data.Keys {
+		key := &Key{Id: k.Id, VerifyUntil: k.VerifyUntil}
+		switch k.Algorithm {
+		case "HS256", "":
+			if len(k.Secret) < 16 {
+				return nil, fmt.Errorf("%s: key %q: secret should be at least 16 characters", path, k.Id)
+			}
+			h := HS256Signer([]byte(k.Secret))
+			key.Signer, key.Verifier = h, h
+		case "RS256", "ES256", "EdDSA":
+			if k.PEM == "" {
+				return nil, fmt.Errorf("%s: key %q: missing pem file", path, k.Id)
+			}
+			pemFile := k.PEM
+			if !filepath.IsAbs(pemFile) {
+				pemFile = filepath.Join(filepath.Dir(path), pemFile)
+			}
+			if key.Signer, key.Verifier, err = LoadPEMKey(pemFile, k.Algorithm); err != nil {
+				return nil, fmt.Errorf("%s: key %q: %w", path, k.Id, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s: key %q: unsupported algorithm %q", path, k.Id, k.Algorithm)
+		}
+		if err := kr.Add(key); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(kr.keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	} else if err := kr.SetActive(data.Active); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	} else if k := kr.keys[data.Active]; !k.VerifyUntil.IsZero() {
+		return nil, fmt.Errorf("%s: active key %q can't be retired", path, data.Active)
+	} else if k.Signer == nil {
+		return nil, fmt.Errorf("%s: active key %q needs a private key", path, data.Active)
+	}
+	return kr, nil
+}
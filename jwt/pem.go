@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// minRSABits is the smallest RSA modulus that LoadPEMKey accepts.
+const minRSABits = 2048
+
+// LoadPEMKey reads a private or public key for the algorithm from a PEM file.
+// A private key returns both a signer and a verifier.
+// A public key returns a nil signer, so it can only verify tokens.
+// RSA keys must be at least 2048 bits and ES256 keys must use P-256.
+func LoadPEMKey(path, alg string) (Signer, Verifier, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s: no pem data", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported pem type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	errMismatch := fmt.Errorf("%s: %T is not a key for %s", path, key, alg)
+	switch alg {
+	case "RS256":
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			if k.N.BitLen() < minRSABits {
+				return nil, nil, fmt.Errorf("%s: RS256 requires a key of at least %d bits", path, minRSABits)
+			}
+			s := RS256Signer(k)
+			return s, s, nil
+		case *rsa.PublicKey:
+			if k.N.BitLen() < minRSABits {
+				return nil, nil, fmt.Errorf("%s: RS256 requires a key of at least %d bits", path, minRSABits)
+			}
+			return nil, RS256Verifier(k), nil
+		}
+	case "ES256":
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			if !isP256(&k.PublicKey) {
+				return nil, nil, errors.New(path + ": ES256 requires a P-256 key")
+			}
+			s := ES256Signer(k)
+			return s, s, nil
+		case *ecdsa.PublicKey:
+			if !isP256(k) {
+				return nil, nil, errors.New(path + ": ES256 requires a P-256 key")
+			}
+			return nil, ES256Verifier(k), nil
+		}
+	case "EdDSA":
+		switch k := key.(type) {
+		case ed25519.PrivateKey:
+			s := EdDSASigner(k)
+			return s, s, nil
+		case ed25519.PublicKey:
+			return nil, EdDSAVerifier(k), nil
+		}
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported algorithm %q", path, alg)
+	}
+	return nil, nil, errMismatch
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RevocationList is the set of token ids that have been revoked before they expired.
+// It is persisted to a JSON file so that revocations survive a restart.
+// Entries are dropped once the token would have expired anyway,
+// which is the expiration time plus the leeway allowed when checking claims.
+type RevocationList struct {
+	sync.Mutex
+	path    string
+	leeway  int64            // seconds past expiration that tokens are still accepted
+	revoked map[string]int64 // jti to expiration time (unix seconds)
+	dirty   bool             // entries were dropped on load but not saved
+}
+
+// LoadRevocationList reads the list from the file.
+// A missing file is treated as an empty list.
+// The leeway must be the one used to check token claims, so that entries
+// are kept for as long as the token could still be accepted.
+// Expired entries are dropped from memory but the file isn't written,
+// so commands that only read the list don't change the data folder.
+func LoadRevocationList(path string, leeway time.Duration) (*RevocationList, error) {
+	rl := &RevocationList{path: path, leeway: int64(leeway / time.Second), revoked: make(map[string]int64)}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rl, nil
+		}
+		return nil, err
+	} else if err = json.Unmarshal(b, &rl.revoked); err != nil {
+		return nil, err
+	}
+	rl.Lock()
+	defer rl.Unlock()
+	rl.dirty = rl.drop()
+	return rl, nil
+}
+
+// IsRevoked returns true if the token id has been revoked.
+func (rl *RevocationList) IsRevoked(jti string) bool {
+	if rl == nil || jti == "" {
+		return false
+	}
+	rl.Lock()
+	defer rl.Unlock()
+	_, ok := rl.revoked[jti]
+	return ok
+}
+
+// Len returns the number of entries in the list.
+func (rl *RevocationList) Len() int {
+	rl.Lock()
+	defer rl.Unlock()
+	return len(rl.revoked)
+}
+
+// Prune removes entries for tokens that have expired and saves the list.
+func (rl *RevocationList) Prune() error {
+	rl.Lock()
+	defer rl.Unlock()
+	if !rl.drop() && !rl.dirty {
+		return nil
+	}
+	return rl.save()
+}
+
+// drop removes entries for tokens that can no longer be accepted and
+// returns true if any were removed. Must be called with the lock held.
+func (rl *RevocationList) drop() bool {
+	now, changed := time.Now().Unix(), false
+	for jti, exp := range rl.revoked {
+		if exp+rl.leeway < now {
+			delete(rl.revoked, jti)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Revoke adds the token id to the list and saves it.
+func (rl *RevocationList) Revoke(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return ErrBadRequest
+	}
+	rl.Lock()
+	defer rl.Unlock()
+	rl.revoked[jti] = expiresAt.Unix()
+	return rl.save()
+}
+
+// save writes the list to a temporary file and renames it so that
+// a crash never leaves a partial file behind. Must be called with the lock held.
+func (rl *RevocationList) save() error {
+	if rl.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(rl.revoked, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(rl.path), ".revoked-*.json")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), rl.path); err != nil {
+		return err
+	}
+	rl.dirty = false
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRevocationLeeway checks that a revoked token stays revoked until its
+// expiration plus the leeway, and that loading the list doesn't write it.
+func TestRevocationLeeway(t *testing.T) {
+	now := time.Now().Unix()
+	path := filepath.Join(t.TempDir(), "revoked.json")
+	b, err := json.Marshal(map[string]int64{
+		"in-leeway": now - 30,  // expired, but CheckClaims still accepts it
+		"gone":      now - 120, // past the leeway
+		"live":      now + 3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rl, err := LoadRevocationList(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for jti, want := range map[string]bool{"in-leeway": true, "gone": false, "live": true} {
+		if got := rl.IsRevoked(jti); got != want {
+			t.Errorf("load: %s: want revoked %v, got %v", jti, want, got)
+		}
+	}
+	if after, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if string(after) != string(b) {
+		t.Errorf("load: file was rewritten")
+	}
+
+	if err := rl.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if !rl.IsRevoked("in-leeway") {
+		t.Errorf("prune: dropped a token that is still inside the leeway")
+	}
+	var saved map[string]int64
+	if after, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(after, &saved); err != nil {
+		t.Fatal(err)
+	} else if len(saved) != 2 {
+		t.Errorf("prune: want 2 entries saved, got %d", len(saved))
+	}
+}
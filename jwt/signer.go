@@ -23,8 +23,15 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"math/big"
 )
 
 // Signer interface
@@ -33,7 +40,14 @@ type Signer interface {
 	Sign(msg []byte) ([]byte, error)
 }
 
-// HS256 implements a Signer using HMAC256.
+// Verifier interface.
+// Verifiers for the asymmetric algorithms only need the public key.
+type Verifier interface {
+	Algorithm() string
+	Verify(msg, sig []byte) error
+}
+
+// HS256 implements a Signer and Verifier using HMAC256.
 type HS256 struct {
 	secret []byte
 }
@@ -57,3 +71,155 @@ func (h *HS256) Sign(msg []byte) ([]byte, error) {
 	}
 	return hm.Sum(nil), nil
 }
+
+// Verify implements the Verifier interface
+func (h *HS256) Verify(msg, sig []byte) error {
+	expected, err := h.Sign(msg)
+	if err != nil {
+		return err
+	} else if !hmac.Equal(expected, sig) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// RS256 implements a Signer and Verifier using RSASSA-PKCS1-v1_5 with SHA-256.
+type RS256 struct {
+	priv *rsa.PrivateKey
+	pub  *rsa.PublicKey
+}
+
+func RS256Signer(priv *rsa.PrivateKey) *RS256 {
+	return &RS256{priv: priv, pub: &priv.PublicKey}
+}
+
+func RS256Verifier(pub *rsa.PublicKey) *RS256 {
+	return &RS256{pub: pub}
+}
+
+// Algorithm implements the Signer interface
+func (r *RS256) Algorithm() string {
+	return "RS256"
+}
+
+// PublicKey returns the key used to verify signatures.
+func (r *RS256) PublicKey() crypto.PublicKey {
+	return r.pub
+}
+
+// Sign implements the Signer interface
+func (r *RS256) Sign(msg []byte) ([]byte, error) {
+	if r.priv == nil {
+		return nil, ErrMissingSigner
+	}
+	digest := sha256.Sum256(msg)
+	return rsa.SignPKCS1v15(rand.Reader, r.priv, crypto.SHA256, digest[:])
+}
+
+// Verify implements the Verifier interface
+func (r *RS256) Verify(msg, sig []byte) error {
+	digest := sha256.Sum256(msg)
+	if err := rsa.VerifyPKCS1v15(r.pub, crypto.SHA256, digest[:], sig); err != nil {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// ES256 implements a Signer and Verifier using ECDSA on P-256 with SHA-256.
+// Signatures are the 64 byte r || s form that JWS requires, not ASN.1.
+type ES256 struct {
+	priv *ecdsa.PrivateKey
+	pub  *ecdsa.PublicKey
+}
+
+func ES256Signer(priv *ecdsa.PrivateKey) *ES256 {
+	return &ES256{priv: priv, pub: &priv.PublicKey}
+}
+
+func ES256Verifier(pub *ecdsa.PublicKey) *ES256 {
+	return &ES256{pub: pub}
+}
+
+// Algorithm implements the Signer interface
+func (e *ES256) Algorithm() string {
+	return "ES256"
+}
+
+// PublicKey returns the key used to verify signatures.
+func (e *ES256) PublicKey() crypto.PublicKey {
+	return e.pub
+}
+
+// Sign implements the Signer interface
+func (e *ES256) Sign(msg []byte) ([]byte, error) {
+	if e.priv == nil {
+		return nil, ErrMissingSigner
+	}
+	digest := sha256.Sum256(msg)
+	r, s, err := ecdsa.Sign(rand.Reader, e.priv, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig, nil
+}
+
+// Verify implements the Verifier interface
+func (e *ES256) Verify(msg, sig []byte) error {
+	if len(sig) != 64 {
+		return ErrUnauthorized
+	}
+	digest := sha256.Sum256(msg)
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(e.pub, digest[:], r, s) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// EdDSA implements a Signer and Verifier using Ed25519.
+type EdDSA struct {
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+func EdDSASigner(priv ed25519.PrivateKey) *EdDSA {
+	return &EdDSA{priv: priv, pub: priv.Public().(ed25519.PublicKey)}
+}
+
+func EdDSAVerifier(pub ed25519.PublicKey) *EdDSA {
+	return &EdDSA{pub: pub}
+}
+
+// Algorithm implements the Signer interface
+func (e *EdDSA) Algorithm() string {
+	return "EdDSA"
+}
+
+// PublicKey returns the key used to verify signatures.
+func (e *EdDSA) PublicKey() crypto.PublicKey {
+	return e.pub
+}
+
+// Sign implements the Signer interface
+func (e *EdDSA) Sign(msg []byte) ([]byte, error) {
+	if e.priv == nil {
+		return nil, ErrMissingSigner
+	}
+	return ed25519.Sign(e.priv, msg), nil
+}
+
+// Verify implements the Verifier interface
+func (e *EdDSA) Verify(msg, sig []byte) error {
+	if !ed25519.Verify(e.pub, msg, sig) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// isP256 reports whether the key is on the curve that ES256 requires.
+func isP256(pub *ecdsa.PublicKey) bool {
+	return pub.Curve == elliptic.P256()
+}
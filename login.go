@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mdhender/fhdb/accounts"
+	"github.com/mdhender/fhdb/handlers"
+	"github.com/mdhender/fhdb/ports"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// handleLogin checks credentials against the game's player file and returns a token for the game.
+// Browsers can ask for the token to be set in the session cookie instead of the response body.
+// Failed attempts are limited per remote host and per login name.
+func (s *Server) handleLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ports.LoginRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Username = strings.TrimSpace(req.Username)
+
+		g := getGame(r)
+		keys := []string{"host:" + handlers.RemoteHost(r), "login:" + g.id + "/" + strings.ToLower(req.Username)}
+		for i, key := range keys {
+			if ok, wait := s.loginLimiter.Allow(key); !ok {
+				for _, allowed := range keys[:i] {
+					s.loginLimiter.Refund(allowed) // attempts that are turned away don't count
+				}
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		// the attempt was counted by Allow; only failures should stay counted.
+		acct, err := g.accounts().Authenticate(req.Username, req.Password)
+		if err != nil {
+			http.Error(w, accounts.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+			return
+		}
+		for _, key := range keys {
+			s.loginLimiter.Refund(key)
+		}
+
+		rsp, err := s.newLoginResponse(g.id, acct.SpeciesId, acct.Username, acct.Email, acct.Roles)
+		if err != nil {
+			log.Printf("[login] %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if req.Cookie {
+			s.setSessionCookies(w, &rsp)
+		}
+		if info := handlers.GetRequestInfo(r); info != nil {
+			info.Game, info.SpeciesId, info.Username = g.id, acct.SpeciesId, acct.Username
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		jsonOk(w, r, rsp)
+	}
+}
+
+// handleLogout revokes the token used to make the request and clears the session cookie.
+func (s *Server) handleLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess := handlers.GetSession(r)
+		if sess == nil || !sess.Authenticated {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if sess.TokenId == "" {
+			// we can't revoke a token without an id, so don't pretend that we did.
+			http.Error(w, "token can't be revoked", http.StatusBadRequest)
+			return
+		}
+		if err := s.revokeSession(sess); err != nil {
+			log.Printf("[logout] %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if sess.FromCookie {
+			handlers.ClearSessionCookies(w, s.cookieSameSite)
+		}
+		handlers.Summarize(r, "revoked token %q", sess.TokenId)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleRefreshToken trades a valid token for a new one with a fresh expiration.
+// The new token is for the same game as the old one.
+// The old token is revoked so that only one of the pair can be used.
+func (s *Server) handleRefreshToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess := handlers.GetSession(r)
+		if sess == nil || !sess.Authenticated {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if sess.TokenId == "" {
+			// we can't revoke a token without an id, so we won't extend it either.
+			http.Error(w, "token can't be refreshed", http.StatusBadRequest)
+			return
+		}
+		var roles []string
+		for role := range sess.Roles {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		// issue the new token first so that a failure doesn't leave the caller without one.
+		rsp, err := s.newLoginResponse(sess.Game, sess.SpeciesId, sess.Username, sess.Email, roles)
+		if err != nil {
+			log.Printf("[refresh] %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err := s.revokeSession(sess); err != nil {
+			log.Printf("[refresh] %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		handlers.Summarize(r, "refreshed token %q", sess.TokenId)
+		if sess.FromCookie {
+			s.setSessionCookies(w, &rsp)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		jsonOk(w, r, rsp)
+	}
+}
+
+// newLoginResponse issues a new token for the subject in a game.
+func (s *Server) newLoginResponse(game string, speciesId int, username, email string, roles []string) (ports.LoginResponse, error) {
+	expiresAt := time.Now().Add(s.tokenTTL).UTC()
+	token, err := s.tokens.NewToken(s.tokenTTL, game, speciesId, username, email, roles...)
+	if err != nil {
+		return ports.LoginResponse{}, err
+	}
+	return ports.LoginResponse{
+		Token:     token,
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+		Game:      game,
+		SpeciesId: speciesId,
+		Roles:     roles,
+	}, nil
+}
+
+// setSessionCookies moves the token from the response to the session cookie
+// and adds the new CSRF token to the response.
+// Scripts can't read the cookie, so the token must not be in the body either.
+func (s *Server) setSessionCookies(w http.ResponseWriter, rsp *ports.LoginResponse) {
+	expiresAt, err := time.Parse(time.RFC3339, rsp.ExpiresAt)
+	if err != nil {
+		return
+	}
+	rsp.CSRFToken = handlers.SetSessionCookies(w, rsp.Token, expiresAt, s.cookieSameSite)
+	rsp.Token = ""
+}
+
+// revokeSession adds the session's token to the revocation list.
+func (s *Server) revokeSession(sess *handlers.Session) error {
+	if sess.TokenId == "" {
+		return nil // nothing we can do for tokens without an id
+	}
+	if err := s.revocations.Revoke(sess.TokenId, sess.ExpiresAt); err != nil {
+		return err
+	}
+	s.sessions.Forget(sess.TokenId)
+	return nil
+}
+
+// pruneRevocations periodically drops revoked tokens that have expired.
+// It returns when the context is cancelled.
+func (s *Server) pruneRevocations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.revocations.Prune(); err != nil {
+				log.Printf("[revoke] %+v\n", err)
+			}
+		}
+	}
+}
+
+// pruneLoginAttempts periodically forgets failed logins that have left the
+// limiter's window, so that trying many usernames doesn't grow it forever.
+// It returns when the context is cancelled.
+func (s *Server) pruneLoginAttempts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.loginLimiter.Prune()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package logs implements log writers used by the server.
+package logs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.Writer that appends to a file and rotates
+// it once it grows past a maximum size. Rotated files are renamed
+// with a numeric suffix (access.log.1, access.log.2, ...), and only
+// the most recent keep files are retained.
+type RotatingFile struct {
+	sync.Mutex
+	path     string
+	maxBytes int64
+	keep     int
+	size     int64
+	fd       *os.File
+}
+
+// OpenRotatingFile opens (or creates) the file for appending.
+// A maxBytes of zero disables rotation.
+func OpenRotatingFile(path string, maxBytes int64, keep int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxBytes: maxBytes, keep: keep}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Close closes the underlying file.
+func (rf *RotatingFile) Close() error {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.fd == nil {
+		return nil
+	}
+	err := rf.fd.Close()
+	rf.fd = nil
+	return err
+}
+
+// Write implements the io.Writer interface.
+// The file is rotated before a write that would push it past the limit.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.fd == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.fd.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) open() error {
+	fd, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	sb, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return err
+	}
+	rf.fd, rf.size = fd, sb.Size()
+	return nil
+}
+
+// rotate must be called with the lock held.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.fd.Close(); err != nil {
+		return err
+	}
+	rf.fd = nil
+	if rf.keep < 1 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return rf.open()
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.keep))
+	for n := rf.keep - 1; n > 0; n-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, n), fmt.Sprintf("%s.%d", rf.path, n+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return rf.open()
+}
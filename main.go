@@ -23,19 +23,91 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/mdhender/fhdb/audit"
 	"github.com/mdhender/fhdb/config"
 	"github.com/mdhender/fhdb/handlers"
-	"github.com/mdhender/fhdb/store/jsondb"
-	"github.com/mdhender/fhdb/store/memory"
+	"github.com/mdhender/fhdb/jwt"
+	"github.com/mdhender/fhdb/logs"
+	"github.com/mdhender/fhdb/ports"
 	"github.com/mdhender/fhdb/way"
+	"io"
 	"log"
 	"mime"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// exit codes returned by the process
+const (
+	exitOk       = 0
+	exitShutdown = 1 // requests were still running when the drain timed out, or state couldn't be saved
+	exitStartup  = 2 // bad configuration, bad data, or the listener failed
+)
+
+// exitError is returned by run when the error should set a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// commands are the subcommands by name.
+// Each is passed the arguments that follow its name.
+var commands = map[string]func(args []string) error{
+	"calc":     runCalc,
+	"config":   runConfig,
+	"diff":     runDiff,
+	"export":   runExport,
+	"import":   runImport,
+	"passwd":   runPasswd,
+	"replay":   runReplay,
+	"report":   runReport,
+	"routes":   runRoutes,
+	"serve":    runServe,
+	"token":    runToken,
+	"validate": runValidate,
+}
+
+const usage = `usage: fhdb <command> [options]
+
+commands:
+  serve     run the server (the default when the first argument is an option)
+  validate  check the files in the data folder and every game
+  config    show the server configuration and where each value came from
+  import    validate a galaxy file and copy it into a game's folder
+  export    write a game's galaxy file
+  diff      compare two galaxy files
+  token     issue or inspect tokens
+  calc      run game calculations, like the chance of a jump mishap
+  report    summarize the galaxy or a species
+  routes    list the routes the server registers
+  replay    replay recorded requests against a data snapshot
+  passwd    hash a password for the player file
+  help      print this message
+
+Each game is a folder in the data folder, named for the game.
+Commands that work on one game take -game, which can be omitted when there is only one.
+
+Every command accepts -config, -data, and -debug, and reads options from the
+config file and FHOE_ environment variables. Run "fhdb <command> -h" for the rest.
+`
+
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.LUTC) // force logs to be UTC
 
@@ -46,58 +118,232 @@ func main() {
 		os.Exit(2)
 	}
 
-	cfg := config.Default()
-	err := cfg.Load()
-	if err == nil {
-		err = run(cfg)
+	var err error
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		// without a command, the arguments are for the server.
+		err = runServe(os.Args[1:])
+	} else if os.Args[1] == "help" {
+		fmt.Print(usage)
+	} else if cmd, ok := commands[os.Args[1]]; ok {
+		err = cmd(os.Args[2:])
+	} else {
+		fmt.Print(usage)
+		err = fmt.Errorf("unknown command %q", os.Args[1])
 	}
 	if err != nil {
 		fmt.Printf("%+v\n", err)
-		os.Exit(2)
+		var ee *exitError
+		if errors.As(err, &ee) {
+			os.Exit(ee.code)
+		}
+		os.Exit(exitStartup)
 	}
+	os.Exit(exitOk)
+}
+
+// runServe loads the server configuration and runs the server until it is stopped.
+func runServe(args []string) error {
+	cfg := config.Default()
+	if err := cfg.Load(args); err != nil {
+		return err
+	} else if err := cfg.Validate(); err != nil {
+		return err
+	}
+	return run(cfg)
 }
 
 func run(cfg *config.Config) error {
 	if cfg == nil {
 		return fmt.Errorf("missing configuration information")
 	}
-	if cfg.Server.JWT.Key == "" || len(cfg.Server.JWT.Key) < 16 {
-		return fmt.Errorf("jwt key length should be at least 16")
-	}
-
-	jdb, err := jsondb.Read(filepath.Join(cfg.Data, "galaxy.json"))
+	s, err := newServer(cfg)
 	if err != nil {
 		return err
 	}
-
-	s := &Server{
-		Router: way.NewRouter(),
-		ds:     &memory.Store{},
-	}
 	s.Addr = net.JoinHostPort(cfg.Server.Host, fmt.Sprintf("%d", cfg.Server.Port))
 	s.IdleTimeout = cfg.Server.Timeout.Idle
 	s.ReadTimeout = cfg.Server.Timeout.Read
 	s.WriteTimeout = cfg.Server.Timeout.Write
 	s.MaxHeaderBytes = 1 << 20 // TODO: make this configurable
-	s.Data = cfg.Data
-	err = s.ds.Read(jdb)
-	if err != nil {
+	s.ConnContext = connContext
+
+	auditFile := cfg.Server.Audit.File
+	if auditFile == "" {
+		auditFile = filepath.Join(cfg.Data, "audit.log")
+	}
+	if s.audit, err = audit.Open(auditFile, 0); err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.audit.Close()
+	}()
+	for _, g := range s.gameList() {
+		if g.audit, err = audit.Open(filepath.Join(g.dir, "audit.log"), g.store().TurnNumber); err != nil {
+			return err
+		}
+		defer func(al *audit.Log) {
+			_ = al.Close()
+		}(g.audit)
+	}
+
+	if cfg.Server.Record.File != "" {
+		rf, err := logs.OpenRotatingFile(cfg.Server.Record.File, 0, 0)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = rf.Close()
+		}()
+		s.Handler = handlers.Record(s.Handler, rf)
+	}
+
+	var accessLog io.Writer = os.Stdout
+	if cfg.Server.AccessLog.File != "" {
+		rf, err := logs.OpenRotatingFile(cfg.Server.AccessLog.File, int64(cfg.Server.AccessLog.MaxSize)<<20, cfg.Server.AccessLog.Keep)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = rf.Close()
+		}()
+		accessLog = rf
+	}
+	s.Handler = handlers.RequestId(handlers.AccessLog(s.Handler, accessLog, s.debug), cfg.Server.AccessLog.TrustRequestId)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go s.watchData(ctx, cfg.Server.Events.Poll)
+	go s.pruneRevocations(ctx, time.Hour)
+	go s.pruneLoginAttempts(ctx, time.Minute)
+
+	// event streams never go idle on their own, so close them when shutdown starts.
+	for _, g := range s.gameList() {
+		s.RegisterOnShutdown(g.events.Close)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if cfg.Server.TLS.Serve {
+			log.Printf("[main] serving TLS on %s\n", s.Addr)
+			errs <- s.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+			return
+		}
+		log.Printf("[main] listening on %s\n", s.Addr)
+		errs <- s.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
 		return err
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process without waiting
+
+	return s.shutdown(cfg.Server.Timeout.Shutdown)
+}
+
+// shutdown stops accepting connections, waits for active requests to finish,
+// and then saves every game's store.
+func (s *Server) shutdown(timeout time.Duration) error {
+	log.Printf("[main] shutting down, waiting up to %v for requests to finish\n", timeout)
+	s.stateLock.Lock()
+	s.shuttingDown = true
+	s.stateLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	drainErr := s.Shutdown(ctx)
+	if drainErr != nil && !errors.Is(drainErr, http.ErrServerClosed) {
+		log.Printf("[main] drain: %+v\n", drainErr)
+		_ = s.Close()
+	}
+
+	var saveErrs []string
+	for _, g := range s.gameList() {
+		if ds := g.store(); ds != nil {
+			if err := ds.Write(g.dir); errors.Is(err, ports.ErrNotImplemented) {
+				log.Printf("[main] %s: game data is not saved; saving isn't implemented\n", g.id)
+			} else if err != nil {
+				log.Printf("[main] %s: save: %+v\n", g.id, err)
+				saveErrs = append(saveErrs, g.id)
+			}
+		}
+	}
+	if saveErrs != nil {
+		return &exitError{code: exitShutdown, err: fmt.Errorf("saving state: failed for %s", strings.Join(saveErrs, ", "))}
+	}
+	if drainErr != nil && !errors.Is(drainErr, http.ErrServerClosed) {
+		return &exitError{code: exitShutdown, err: fmt.Errorf("draining requests: %w", drainErr)}
+	}
+	log.Printf("[main] shutdown complete\n")
+	return nil
+}
+
+// newServer returns a Server with every game loaded and the routes registered.
+// The caller is responsible for the listener settings and any logging handlers.
+func newServer(cfg *config.Config) (*Server, error) {
+	s := &Server{
+		Router:       way.NewRouter(),
+		metrics:      newServerMetrics(),
+		loginLimiter: handlers.NewRateLimiter(5, 15*time.Minute),
+		sessions:     handlers.NewSessionTracker(),
+		tokenTTL:     cfg.Server.JWT.TTL,
+	}
+	s.Data = cfg.Data
+	s.debug = cfg.Debug
+	s.Events.Heartbeat = cfg.Server.Events.Heartbeat
+	var err error
+	if s.cookieSameSite, err = handlers.ParseSameSite(cfg.Server.Cookies.SameSite); err != nil {
+		return nil, err
+	}
+	if err := s.loadGames(cfg.Data); err != nil {
+		return nil, err
 	}
-	s.Handler = handlers.CORS(handlers.Version(s.Router, s.ds.Version))
+	if s.revocations, err = jwt.LoadRevocationList(filepath.Join(cfg.Data, "revoked.json"), cfg.Server.JWT.Leeway); err != nil {
+		return nil, err
+	}
+	s.keyFile = cfg.Server.JWT.KeyFile
+	if s.tokens, s.keys, err = newTokenFactory(cfg, s.revocations); err != nil {
+		return nil, err
+	}
+	// inGame adds the version header, since each game has its own.
+	s.Handler = handlers.CORS(s.Router.ServeHTTP, handlers.CORSPolicy{
+		Origins:       cfg.Server.CORS.Origins,
+		Credentials:   cfg.Server.CORS.Credentials,
+		Headers:       cfg.Server.CORS.Headers,
+		ExposeHeaders: cfg.Server.CORS.ExposeHeaders,
+		MaxAge:        cfg.Server.CORS.MaxAge,
+	})
 	//err = s.jdb.Write(filepath.Join(cfg.Data, "cluster.json"))
 	//if err != nil {
 	//	return err
 	//}
 
 	if err := s.Routes(cfg); err != nil {
-		return err
+		return nil, err
 	}
+	return s, nil
+}
 
-	if cfg.Server.TLS.Serve {
-		log.Printf("[main] serving TLS on %s\n", s.Addr)
-		return s.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+// newTokenFactory returns a factory that signs with the configured keys.
+// The keyring is nil unless the keys were loaded from a key file.
+func newTokenFactory(cfg *config.Config, rl *jwt.RevocationList) (jwt.Factory, *jwt.Keyring, error) {
+	var f jwt.Factory
+	var kr *jwt.Keyring
+	if cfg.Server.JWT.KeyFile == "" {
+		f = jwt.NewFactory(cfg.Server.JWT.Key)
+	} else {
+		var err error
+		if kr, err = jwt.LoadKeyring(cfg.Server.JWT.KeyFile); err != nil {
+			return f, nil, err
+		}
+		f = jwt.NewKeyringFactory(kr)
 	}
-	log.Printf("[main] listening on %s\n", s.Addr)
-	return s.ListenAndServe()
+	f = f.WithRevocations(rl).WithClaims(jwt.Claims{
+		Issuer:   cfg.Server.JWT.Issuer,
+		Audience: cfg.Server.JWT.Audience,
+		Leeway:   cfg.Server.JWT.Leeway,
+	})
+	return f, kr, nil
 }
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"github.com/mdhender/fhdb/handlers"
+	"github.com/mdhender/fhdb/jwt"
+	"github.com/mdhender/fhdb/metrics"
+	"github.com/mdhender/fhdb/store/memory"
+	"github.com/mdhender/fhdb/way"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serverMetrics are the metrics exposed on the /metrics endpoint.
+type serverMetrics struct {
+	registry     *metrics.Registry
+	requests     *metrics.CounterVec
+	latency      *metrics.HistogramVec
+	authFailures *metrics.CounterVec
+	loadDuration *metrics.GaugeVec
+	objects      *metrics.GaugeVec
+	turn         *metrics.GaugeVec
+	reloads      *metrics.CounterVec
+}
+
+func newServerMetrics() *serverMetrics {
+	reg := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:     reg,
+		requests:     reg.NewCounterVec("fhdb_http_requests_total", "Number of HTTP requests by route pattern.", "method", "route", "status"),
+		latency:      reg.NewHistogramVec("fhdb_http_request_duration_seconds", "HTTP request latency by route pattern.", metrics.DefaultBuckets, "method", "route"),
+		authFailures: reg.NewCounterVec("fhdb_auth_failures_total", "Number of requests rejected by authentication.", "reason"),
+		loadDuration: reg.NewGaugeVec("fhdb_store_load_duration_seconds", "Time taken by the last successful store load.", "game"),
+		objects:      reg.NewGaugeVec("fhdb_store_objects", "Number of objects in the loaded snapshot.", "game", "kind"),
+		turn:         reg.NewGaugeVec("fhdb_turn_number", "Current turn number.", "game"),
+		reloads:      reg.NewCounterVec("fhdb_store_reloads_total", "Number of store loads by outcome.", "game", "outcome"),
+	}
+}
+
+// instrument counts requests and latencies using the route pattern as the label.
+// The pattern keeps the number of series bounded no matter what paths are requested.
+// Requests that didn't match a route are counted as "NotFound",
+// and methods outside the standard set are counted as "other".
+func (m *serverMetrics) instrument(h http.Handler) http.Handler {
+	return handlers.Observe(h, func(r *http.Request, info *handlers.RequestInfo, status int, elapsed time.Duration) {
+		route := way.Pattern(r.Context())
+		if route == "" {
+			route = "NotFound"
+		}
+		method := methodLabel(r.Method)
+		m.requests.With(method, route, strconv.Itoa(status)).Inc()
+		m.latency.With(method, route).Observe(elapsed.Seconds())
+		if info.AuthError != nil {
+			m.authFailures.With(authErrorReason(info.AuthError)).Inc()
+		}
+	})
+}
+
+// observeLoad records the outcome of loading a game's store.
+func (m *serverMetrics) observeLoad(game string, ds *memory.Store, elapsed time.Duration, err error) {
+	if err != nil {
+		m.reloads.With(game, "failure").Inc()
+		return
+	}
+	m.reloads.With(game, "success").Inc()
+	m.loadDuration.With(game).Set(elapsed.Seconds())
+	m.turn.With(game).Set(float64(ds.TurnNumber))
+	if stats, err := ds.GetStats(); err == nil {
+		m.objects.With(game, "systems").Set(float64(stats.Systems))
+		m.objects.With(game, "planets").Set(float64(stats.Planets))
+		m.objects.With(game, "species").Set(float64(stats.Species))
+		m.objects.With(game, "colonies").Set(float64(stats.Colonies))
+		m.objects.With(game, "ships").Set(float64(stats.Ships))
+	}
+}
+
+// methodLabel returns the method as a label value.
+// Clients can send any token as the method, so only the standard ones are kept.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodConnect, http.MethodDelete, http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodPatch, http.MethodPost, http.MethodPut, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// authErrorReason converts an error from the jwt package into a label value.
+func authErrorReason(err error) string {
+	for _, e := range []error{
+		jwt.ErrBadAudience,
+		jwt.ErrBadFactory,
+		jwt.ErrBadIssuer,
+		jwt.ErrBadRequest,
+		jwt.ErrExpired,
+		jwt.ErrIssuedInFuture,
+		jwt.ErrMissingAuthHeader,
+		jwt.ErrKeyRetired,
+		jwt.ErrMissingClaim,
+		jwt.ErrMissingCookie,
+		jwt.ErrMissingSigner,
+		jwt.ErrNotBearer,
+		jwt.ErrNotJWT,
+		jwt.ErrNotYetValid,
+		jwt.ErrRevoked,
+		jwt.ErrUnauthorized,
+		jwt.ErrUnknownKey,
+	} {
+		if errors.Is(err, e) {
+			return strings.ReplaceAll(e.Error(), " ", "_")
+		}
+	}
+	return "malformed" // errors from decoding the header or payload
+}
+
+func (s *Server) handleGetMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := s.metrics.registry.WriteText(w); err != nil {
+			log.Printf("[metrics] %+v\n", err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package metrics implements counters, gauges, and histograms
+// that can be exposed using the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds the metrics exposed by the server.
+type Registry struct {
+	sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+// collector is implemented by every metric type.
+type collector interface {
+	name() string
+	write(w io.Writer) error
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// WriteText writes every registered metric in the text exposition format.
+func (reg *Registry) WriteText(w io.Writer) error {
+	reg.Lock()
+	collectors := append([]collector{}, reg.collectors...)
+	reg.Unlock()
+	for _, c := range collectors {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (reg *Registry) register(c collector) {
+	reg.Lock()
+	defer reg.Unlock()
+	if reg.names[c.name()] {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", c.name()))
+	}
+	reg.names[c.name()] = true
+	reg.collectors = append(reg.collectors, c)
+}
+
+// vec holds the label names and the children of a metric.
+// Children are keyed by their label values joined with a separator
+// that can't appear in valid UTF-8.
+type vec struct {
+	sync.Mutex
+	metric   string
+	help     string
+	labels   []string
+	children map[string]interface{}
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{metric: name, help: help, labels: labels, children: make(map[string]interface{})}
+}
+
+func (v *vec) name() string {
+	return v.metric
+}
+
+// child returns the child for the label values, creating it if needed.
+func (v *vec) child(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s: expected %d label values, got %d", v.metric, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.Lock()
+	defer v.Unlock()
+	c, ok := v.children[key]
+	if !ok {
+		c = create()
+		v.children[key] = c
+	}
+	return c
+}
+
+// sortedKeys returns the child keys in a stable order.
+func (v *vec) sortedKeys() []string {
+	var keys []string
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelPairs formats the labels for a child, plus any extra pairs.
+func (v *vec) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) != 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", v.labels[i], escapeLabel(value)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *vec) writeHeader(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metric, escapeHelp(v.help), v.metric, kind)
+	return err
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// formatFloat formats a value the way the exposition format expects.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	sync.Mutex
+	value float64
+}
+
+// NewCounterVec creates and registers a counter.
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{vec: newVec(name, help, labels)}
+	reg.register(cv)
+	return cv
+}
+
+// With returns the counter for the label values.
+func (cv *CounterVec) With(values ...string) *Counter {
+	return cv.child(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+// Add increments the counter. Negative values are ignored.
+func (c *Counter) Add(f float64) {
+	if f < 0 {
+		return
+	}
+	c.Lock()
+	c.value += f
+	c.Unlock()
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (cv *CounterVec) write(w io.Writer) error {
+	if err := cv.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+	cv.Lock()
+	defer cv.Unlock()
+	for _, key := range cv.sortedKeys() {
+		c := cv.children[key].(*Counter)
+		c.Lock()
+		value := c.value
+		c.Unlock()
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", cv.metric, cv.labelPairs(key), formatFloat(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	vec
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	sync.Mutex
+	value float64
+}
+
+// NewGaugeVec creates and registers a gauge.
+func (reg *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	gv := &GaugeVec{vec: newVec(name, help, labels)}
+	reg.register(gv)
+	return gv
+}
+
+// With returns the gauge for the label values.
+func (gv *GaugeVec) With(values ...string) *Gauge {
+	return gv.child(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+// Set sets the value of the gauge.
+func (g *Gauge) Set(f float64) {
+	g.Lock()
+	g.value = f
+	g.Unlock()
+}
+
+func (gv *GaugeVec) write(w io.Writer) error {
+	if err := gv.writeHeader(w, "gauge"); err != nil {
+		return err
+	}
+	gv.Lock()
+	defer gv.Unlock()
+	for _, key := range gv.sortedKeys() {
+		g := gv.children[key].(*Gauge)
+		g.Lock()
+		value := g.value
+		g.Unlock()
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", gv.metric, gv.labelPairs(key), formatFloat(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultBuckets are the upper bounds, in seconds, used for request latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64 // counts[i] is the number of observations <= buckets[i]
+	count   uint64
+	sum     float64
+}
+
+// NewHistogramVec creates and registers a histogram.
+// The buckets must be sorted in increasing order.
+func (reg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	hv := &HistogramVec{vec: newVec(name, help, labels), buckets: buckets}
+	reg.register(hv)
+	return hv
+}
+
+// With returns the histogram for the label values.
+func (hv *HistogramVec) With(values ...string) *Histogram {
+	return hv.child(values, func() interface{} {
+		return &Histogram{buckets: hv.buckets, counts: make([]uint64, len(hv.buckets))}
+	}).(*Histogram)
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(f float64) {
+	h.Lock()
+	defer h.Unlock()
+	for i, le := range h.buckets {
+		if f <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += f
+}
+
+func (hv *HistogramVec) write(w io.Writer) error {
+	if err := hv.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+	hv.Lock()
+	defer hv.Unlock()
+	for _, key := range hv.sortedKeys() {
+		h := hv.children[key].(*Histogram)
+		h.Lock()
+		counts, count, sum := append([]uint64{}, h.counts...), h.count, h.sum
+		h.Unlock()
+		for i, le := range hv.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", hv.metric, hv.labelPairs(key, "le", formatFloat(le)), counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", hv.metric, hv.labelPairs(key, "le", "+Inf"), count); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", hv.metric, hv.labelPairs(key), formatFloat(sum)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", hv.metric, hv.labelPairs(key), count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
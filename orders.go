@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mdhender/fhdb/events"
+	"github.com/mdhender/fhdb/handlers"
+	"github.com/mdhender/fhdb/policy"
+	"github.com/mdhender/fhdb/ports"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// submit orders for the caller's species for the current turn.
+// orders are saved as text in the game's orders folder, replacing any earlier
+// submission for the turn, and the species is sent an orders-received event.
+func (s *Server) handlePostOrders() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess := handlers.GetSession(r)
+		if sess == nil || !sess.Authenticated {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		g := getGame(r)
+		ds := g.store()
+		if ds == nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if !ds.IsSpecies(sess.SpeciesId) || policy.Check(sess.Principal, policy.WriteOrders, sess.SpeciesId, ds) != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		var req ports.OrdersRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		} else if strings.TrimSpace(req.Orders) == "" {
+			http.Error(w, "orders are required", http.StatusBadRequest)
+			return
+		}
+
+		turn, now := ds.TurnNumber, time.Now().UTC()
+		path := filepath.Join(g.dir, "orders", fmt.Sprintf("turn-%04d", turn), fmt.Sprintf("sp%02d.txt", sess.SpeciesId))
+		if err := writeFileAtomic(path, []byte(req.Orders)); err != nil {
+			log.Printf("[orders] %s: %+v\n", g.id, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		handlers.Summarize(r, "received %d bytes of orders for turn %d", len(req.Orders), turn)
+		g.events.Publish(events.OrdersReceived, sess.SpeciesId, ports.OrdersReceivedEvent{
+			TurnNumber: turn,
+			Bytes:      len(req.Orders),
+			ReceivedAt: now.Format(time.RFC3339),
+		})
+		jsonOk(w, r, ports.OrdersResponse{
+			TurnNumber: turn,
+			SpeciesId:  sess.SpeciesId,
+			Bytes:      len(req.Orders),
+			ReceivedAt: now.Format(time.RFC3339),
+		})
+	}
+}
+
+// send a message from the caller's species to another species.
+// messages are appended to the turn's file in the game's messages folder,
+// and the recipient is sent a message-received event.
+func (s *Server) handlePostMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess := handlers.GetSession(r)
+		if sess == nil || !sess.Authenticated {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		g := getGame(r)
+		ds := g.store()
+		if ds == nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if !ds.IsSpecies(sess.SpeciesId) || policy.Check(sess.Principal, policy.WriteDiplomacy, sess.SpeciesId, ds) != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		var req ports.MessageRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		} else if req.To == sess.SpeciesId || !ds.IsSpecies(req.To) {
+			http.Error(w, "to must be the id of another species", http.StatusBadRequest)
+			return
+		} else if strings.TrimSpace(req.Text) == "" {
+			http.Error(w, "text is required", http.StatusBadRequest)
+			return
+		}
+
+		turn, sentAt := ds.TurnNumber, time.Now().UTC().Format(time.RFC3339)
+		line, err := json.Marshal(struct {
+			SentAt string `json:"sent_at"`
+			From   int    `json:"from"`
+			To     int    `json:"to"`
+			Text   string `json:"text"`
+		}{sentAt, sess.SpeciesId, req.To, req.Text})
+		if err == nil {
+			err = appendLine(filepath.Join(g.dir, "messages", fmt.Sprintf("turn-%04d.jsonl", turn)), line)
+		}
+		if err != nil {
+			log.Printf("[messages] %s: %+v\n", g.id, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		handlers.Summarize(r, "sent a message to species %d", req.To)
+		g.events.Publish(events.MessageReceived, req.To, ports.MessageReceivedEvent{
+			TurnNumber: turn,
+			From:       sess.SpeciesId,
+			Text:       req.Text,
+			SentAt:     sentAt,
+		})
+		jsonOk(w, r, ports.MessageResponse{
+			TurnNumber: turn,
+			From:       sess.SpeciesId,
+			To:         req.To,
+			SentAt:     sentAt,
+		})
+	}
+}
+
+// writeFileAtomic writes the file through a temporary file and a rename
+// so that readers never see a partial file. It creates the folder if needed.
+func writeFileAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// appendLine adds a line to the end of the file, creating the file and its folder if needed.
+func appendLine(path string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// a single write keeps lines from concurrent requests from interleaving.
+	_, err = fd.Write(append(line, '\n'))
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
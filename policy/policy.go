@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package policy decides what a player may see and do.
+// All authorization checks should go through Check.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Scope is a permission that a principal can hold.
+type Scope string
+
+const (
+	Admin          Scope = "admin"           // game master, may do anything
+	ReadAllyIntel  Scope = "read:ally-intel" // read data that allies share
+	ReadSelf       Scope = "read:self"       // read the principal's own species
+	WriteDiplomacy Scope = "write:diplomacy" // change relationships with other species
+	WriteOrders    Scope = "write:orders"    // submit orders for the principal's species
+)
+
+// PlayerScopes are granted to a player for their own species.
+var PlayerScopes = []Scope{ReadSelf, ReadAllyIntel, WriteOrders, WriteDiplomacy}
+
+// AllyScopes are the scopes that a species extends to the species it has declared as allies.
+// Nothing that changes state is ever shared.
+var AllyScopes = map[Scope]bool{
+	ReadAllyIntel: true,
+}
+
+// Relations reports the relationships between species.
+type Relations interface {
+	// IsAlly returns true if species of has declared species with to be an ally.
+	IsAlly(of, with int) bool
+}
+
+// Principal is the species making a request and the scopes it holds.
+type Principal struct {
+	SpeciesId int
+	Scopes    map[Scope]bool
+	// Known is the other species named by "SPnn" roles. The principal knows
+	// them and may use the AllyScopes on them as though they were allies.
+	Known map[int]bool
+}
+
+// FromRoles returns the principal for a token's species and roles.
+// The "GM" role grants Admin, the species' own "SPnn" role grants PlayerScopes,
+// and any role that names a scope grants that scope.
+// An "SPnn" role for another species grants ReadAllyIntel on that species,
+// which is what those roles allowed before scopes existed; tokens issued
+// like "-species 7 -roles SP07,SP03" can still read species 3.
+// Other roles grant nothing.
+func FromRoles(speciesId int, roles []string) Principal {
+	p := Principal{SpeciesId: speciesId, Scopes: make(map[Scope]bool), Known: make(map[int]bool)}
+	self := fmt.Sprintf("SP%02d", speciesId)
+	for _, role := range roles {
+		switch Scope(role) {
+		case Admin, ReadAllyIntel, ReadSelf, WriteDiplomacy, WriteOrders:
+			p.Scopes[Scope(role)] = true
+			continue
+		}
+		if role == "GM" {
+			p.Scopes[Admin] = true
+		} else if speciesId > 0 && role == self {
+			for _, scope := range PlayerScopes {
+				p.Scopes[scope] = true
+			}
+		} else if id, ok := speciesRole(role); ok && id != speciesId {
+			p.Known[id] = true
+			p.Scopes[ReadAllyIntel] = true
+		}
+	}
+	return p
+}
+
+// speciesRole returns the species id from a role like "SP07".
+func speciesRole(role string) (int, bool) {
+	if !strings.HasPrefix(role, "SP") {
+		return 0, false
+	}
+	id, err := strconv.Atoi(role[2:])
+	if err != nil || id < 1 || role != fmt.Sprintf("SP%02d", id) {
+		return 0, false
+	}
+	return id, true
+}
+
+// IsAdmin returns true if the principal holds the Admin scope.
+func (p Principal) IsAdmin() bool {
+	return p.Scopes[Admin]
+}
+
+// Check returns ErrForbidden unless the principal may use the scope on the target species.
+// Admins may do anything. Otherwise the principal must hold the scope, and the target
+// must be the principal's own species or, for scopes in AllyScopes, a species that
+// has declared the principal an ally or that the principal's roles name.
+func Check(p Principal, scope Scope, target int, rel Relations) error {
+	if p.Scopes[Admin] {
+		return nil
+	} else if !p.Scopes[scope] {
+		return ErrForbidden
+	} else if p.SpeciesId > 0 && target == p.SpeciesId {
+		return nil
+	} else if AllyScopes[scope] && p.Known[target] {
+		return nil
+	} else if AllyScopes[scope] && rel != nil && p.SpeciesId > 0 && rel.IsAlly(target, p.SpeciesId) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// ReadScope returns the scope needed to read data about the target species.
+func ReadScope(p Principal, target int) Scope {
+	if target == p.SpeciesId {
+		return ReadSelf
+	}
+	return ReadAllyIntel
+}
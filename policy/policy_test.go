@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package policy
+
+import (
+	"testing"
+)
+
+// allies is a Relations where each species lists the species it has declared allies.
+type allies map[int][]int
+
+func (a allies) IsAlly(of, with int) bool {
+	for _, id := range a[of] {
+		if id == with {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheck(t *testing.T) {
+	rel := allies{4: {7}}
+	for _, tc := range []struct {
+		name      string
+		speciesId int
+		roles     []string
+		scope     Scope
+		target    int
+		ok        bool
+	}{
+		{"own species", 7, []string{"SP07"}, ReadSelf, 7, true},
+		{"own orders", 7, []string{"SP07"}, WriteOrders, 7, true},
+		{"other species", 7, []string{"SP07"}, ReadAllyIntel, 3, false},
+		{"declared ally", 7, []string{"SP07"}, ReadAllyIntel, 4, true},
+		{"orders for ally", 7, []string{"SP07"}, WriteOrders, 4, false},
+		// the roles from "fhdb token issue -species 7 -roles SP07,SP03"
+		{"role for other species", 7, []string{"SP07", "SP03"}, ReadAllyIntel, 3, true},
+		{"orders for role species", 7, []string{"SP07", "SP03"}, WriteOrders, 3, false},
+		{"unpadded role", 7, []string{"SP07", "SP3"}, ReadAllyIntel, 3, false},
+		{"role without own role", 7, []string{"SP03"}, ReadSelf, 7, false},
+		{"gm", 0, []string{"GM"}, WriteOrders, 3, true},
+		{"no roles", 7, nil, ReadSelf, 7, false},
+	} {
+		p := FromRoles(tc.speciesId, tc.roles)
+		if err := Check(p, tc.scope, tc.target, rel); (err == nil) != tc.ok {
+			t.Errorf("%s: %s on %d: want ok %v, got %v", tc.name, tc.scope, tc.target, tc.ok, err)
+		}
+	}
+}
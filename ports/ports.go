@@ -26,14 +26,71 @@ import "errors"
 
 var ErrInternalError = errors.New("internal error")
 var ErrNotFound = errors.New("not found")
+var ErrNotImplemented = errors.New("not implemented")
 var ErrUnauthorized = errors.New("unauthorized")
 
+type AdminSessionResponse struct {
+	TokenId   string   `json:"token_id,omitempty"`
+	Game      string   `json:"game,omitempty"` // empty for server-wide tokens
+	SpeciesId int      `json:"species_id"`
+	Username  string   `json:"username"`
+	Roles     []string `json:"roles"`
+	IssuedAt  string   `json:"issued_at"`
+	ExpiresAt string   `json:"expires_at"`
+	FirstSeen string   `json:"first_seen"`
+	LastSeen  string   `json:"last_seen"`
+	Remote    string   `json:"remote"`
+	Requests  int      `json:"requests"`
+	Streams   int      `json:"streams"` // open event streams for the species
+}
+
+type AdminRouteResponse struct {
+	Method     string   `json:"method"`
+	Pattern    string   `json:"pattern"`
+	Middleware []string `json:"middleware"` // outermost first
+	Auth       string   `json:"auth"`       // one of public, token, game token, admin, or server admin
+}
+
+type AdminSpeciesResponse struct {
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	Government struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"government"`
+	AutoOrders          bool                     `json:"auto_orders"`
+	BankedEconomicUnits int                      `json:"banked_economic_units"`
+	FleetCost           int                      `json:"fleet_cost"`
+	FleetPercentCost    float64                  `json:"fleet_percent_cost"`
+	Tech                map[string]*TechResponse `json:"tech"`
+	Allies              []int                    `json:"allies"`
+	Enemies             []int                    `json:"enemies"`
+	Neutral             []int                    `json:"neutral"`
+}
+
 type Coords struct {
 	X int `json:"x"`
 	Y int `json:"y"`
 	Z int `json:"z"`
 }
 
+type DataReloadedEvent struct {
+	Version    string `json:"version"`
+	TurnNumber int    `json:"turn_number"`
+}
+
+type GameResponse struct {
+	Id         string `json:"id"`
+	Version    string `json:"version"`
+	TurnNumber int    `json:"turn_number"`
+	SpeciesId  int    `json:"species_id"` // the caller's species in the game
+	Link       string `json:"link"`
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
 type KnownSpeciesResponse struct {
 	Id        int `json:"id"`
 	Diplomacy struct {
@@ -43,6 +100,21 @@ type KnownSpeciesResponse struct {
 	}
 }
 
+type LoginRequest struct {
+	Username string `json:"username"` // username or email
+	Password string `json:"password"`
+	Cookie   bool   `json:"cookie,omitempty"` // browsers set this to get the token in the session cookie
+}
+
+type LoginResponse struct {
+	Token     string   `json:"token,omitempty"` // omitted when the token is in the session cookie
+	Game      string   `json:"game"`
+	ExpiresAt string   `json:"expires_at"`
+	SpeciesId int      `json:"species_id"`
+	Roles     []string `json:"roles"`
+	CSRFToken string   `json:"csrf_token,omitempty"` // echo in X-CSRF-Token when using the session cookie
+}
+
 type MishapResponse struct {
 	From         Coords  `json:"from"`
 	To           Coords  `json:"to"`
@@ -51,10 +123,40 @@ type MishapResponse struct {
 	MishapChance float64 `json:"mishap_chance"`
 }
 
+type ReadinessResponse struct {
+	Game       string   `json:"game"`
+	Ready      bool     `json:"ready"`
+	Reloading  bool     `json:"reloading"`
+	Version    string   `json:"version,omitempty"`
+	TurnNumber int      `json:"turn_number"`
+	LoadedAt   string   `json:"loaded_at,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Warnings   []string `json:"warnings"`
+}
+
+type ServerReadinessResponse struct {
+	Ready        bool                 `json:"ready"` // true only if every game is ready
+	ShuttingDown bool                 `json:"shutting_down"`
+	Games        []*ReadinessResponse `json:"games"`
+}
+
 type SpeciesResponse struct {
 	Id int `json:"id"`
 }
 
+type StoreStatsResponse struct {
+	Version     string   `json:"version"`
+	TurnNumber  int      `json:"turn_number"`
+	LoadedAt    string   `json:"loaded_at,omitempty"`
+	Systems     int      `json:"systems"`
+	Planets     int      `json:"planets"`
+	Species     int      `json:"species"`
+	Colonies    int      `json:"colonies"`
+	Ships       int      `json:"ships"`
+	Subscribers int      `json:"subscribers"`
+	Warnings    []string `json:"warnings"`
+}
+
 type SystemResponse struct {
 	Id      string `json:"id"`
 	Coords  Coords `json:"coords"`
@@ -69,6 +171,57 @@ type SystemsResponse struct {
 	Link    string `json:"link"`
 }
 
+type TechResponse struct {
+	Level     int `json:"level"`
+	Init      int `json:"init"`
+	Knowledge int `json:"knowledge"`
+	BankedXp  int `json:"xp"`
+}
+
+type TurnNumberRequest struct {
+	TurnNumber int `json:"turn_number"`
+}
+
+type MessageRequest struct {
+	To   int    `json:"to"` // id of the species to send the message to
+	Text string `json:"text"`
+}
+
+type MessageResponse struct {
+	TurnNumber int    `json:"turn_number"`
+	From       int    `json:"from"`
+	To         int    `json:"to"`
+	SentAt     string `json:"sent_at"`
+}
+
+type MessageReceivedEvent struct {
+	TurnNumber int    `json:"turn_number"`
+	From       int    `json:"from"`
+	Text       string `json:"text"`
+	SentAt     string `json:"sent_at"`
+}
+
+type OrdersRequest struct {
+	Orders string `json:"orders"`
+}
+
+type OrdersResponse struct {
+	TurnNumber int    `json:"turn_number"`
+	SpeciesId  int    `json:"species_id"`
+	Bytes      int    `json:"bytes"`
+	ReceivedAt string `json:"received_at"`
+}
+
+type OrdersReceivedEvent struct {
+	TurnNumber int    `json:"turn_number"`
+	Bytes      int    `json:"bytes"`
+	ReceivedAt string `json:"received_at"`
+}
+
+type TurnPublishedEvent struct {
+	TurnNumber int `json:"turn_number"`
+}
+
 type TurnNumberResponse struct {
 	TurnNumber int `json:"turn_number"`
 }
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"github.com/mdhender/fhdb/accounts"
+	"github.com/mdhender/fhdb/events"
+	"github.com/mdhender/fhdb/jwt"
+	"github.com/mdhender/fhdb/ports"
+	"github.com/mdhender/fhdb/store/jsondb"
+	"github.com/mdhender/fhdb/store/memory"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// loadStore reads the galaxy file from the data directory
+// and converts it to an in-memory store.
+func loadStore(path string) (*memory.Store, error) {
+	return loadGalaxy(filepath.Join(path, "galaxy.json"))
+}
+
+// loadGalaxy reads a galaxy file and converts it to an in-memory store.
+func loadGalaxy(filename string) (*memory.Store, error) {
+	jdb, err := jsondb.Read(filename)
+	if err != nil {
+		return nil, err
+	}
+	ds := &memory.Store{}
+	if err := ds.Read(jdb); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// accounts returns the game's current player accounts.
+func (g *game) accounts() *accounts.Accounts {
+	g.dsLock.RLock()
+	defer g.dsLock.RUnlock()
+	return g.accts
+}
+
+// store returns the game's current in-memory store.
+// Handlers must use this rather than the field since a reload can replace it.
+func (g *game) store() *memory.Store {
+	g.dsLock.RLock()
+	defer g.dsLock.RUnlock()
+	return g.ds
+}
+
+// reload replaces the game's store and player accounts with the contents
+// of its folder and tells connected clients about the change.
+// While the reload is running, and after it fails, the game reports that it isn't ready.
+// The previous store (if any) keeps answering requests in the meantime.
+func (g *game) reload() error {
+	g.reloadLock.Lock()
+	defer g.reloadLock.Unlock()
+
+	g.dsLock.Lock()
+	g.loadState.reloading = true
+	g.dsLock.Unlock()
+
+	var modTime time.Time
+	if sb, err := os.Stat(filepath.Join(g.dir, "galaxy.json")); err == nil {
+		modTime = sb.ModTime()
+	}
+	started := time.Now()
+	ds, err := loadStore(g.dir)
+	g.metrics.observeLoad(g.id, ds, time.Since(started), err)
+	var accts *accounts.Accounts
+	if err == nil {
+		accts, err = accounts.Load(filepath.Join(g.dir, "players.json"))
+	}
+	if err != nil {
+		g.dsLock.Lock()
+		g.loadState.reloading, g.loadState.err = false, err
+		g.dsLock.Unlock()
+		return err
+	}
+
+	g.dsLock.Lock()
+	prev := g.ds
+	g.ds, g.dataModTime = ds, modTime
+	g.accts = accts
+	g.loadState.reloading, g.loadState.err = false, nil
+	g.loadState.loadedAt = time.Now().UTC()
+	g.dsLock.Unlock()
+
+	g.events.Publish(events.DataReloaded, 0, ports.DataReloadedEvent{Version: ds.Version, TurnNumber: ds.TurnNumber})
+	if prev != nil && prev.TurnNumber != ds.TurnNumber {
+		log.Printf("[reload] %s: turn %d published\n", g.id, ds.TurnNumber)
+		g.rotateAudit(ds.TurnNumber)
+		g.events.Publish(events.TurnPublished, 0, ports.TurnPublishedEvent{TurnNumber: ds.TurnNumber})
+	}
+	return nil
+}
+
+// reloadKeys re-reads the signing key file, if there is one.
+// Tokens signed with keys that are still in the file stay valid.
+func (s *Server) reloadKeys() error {
+	if s.keys == nil {
+		return nil
+	}
+	kr, err := jwt.LoadKeyring(s.keyFile)
+	if err != nil {
+		return err
+	}
+	s.keys.Replace(kr)
+	log.Printf("[reload] signing keys reloaded from %q\n", s.keyFile)
+	return nil
+}
+
+// setTurnNumber replaces the game's store with a copy that has the new turn number.
+// Copying keeps handlers that are reading the current store from seeing a partial update.
+func (g *game) setTurnNumber(turn int) error {
+	g.dsLock.Lock()
+	if g.ds == nil {
+		g.dsLock.Unlock()
+		return ports.ErrInternalError
+	}
+	ds := *g.ds
+	ds.TurnNumber = turn
+	g.ds = &ds
+	g.dsLock.Unlock()
+
+	// the turn isn't saved, so the files on disk are still for the old turn and
+	// the audit log stays with it. a reload or restart puts the old turn back.
+	log.Printf("[admin] %s: turn %d published (not saved)\n", g.id, turn)
+	g.metrics.turn.With(g.id).Set(float64(turn))
+	g.events.Publish(events.TurnPublished, 0, ports.TurnPublishedEvent{TurnNumber: turn})
+	return nil
+}
+
+// readiness reports whether the game has a successfully loaded store
+// and no reload is running.
+func (g *game) readiness() *ports.ReadinessResponse {
+	g.dsLock.RLock()
+	defer g.dsLock.RUnlock()
+	rsp := &ports.ReadinessResponse{
+		Game:      g.id,
+		Ready:     g.ds != nil && !g.loadState.reloading && g.loadState.err == nil,
+		Reloading: g.loadState.reloading,
+		Warnings:  []string{},
+	}
+	if g.ds != nil {
+		rsp.Version = g.ds.Version
+		rsp.TurnNumber = g.ds.TurnNumber
+		rsp.LoadedAt = g.loadState.loadedAt.Format(time.RFC3339)
+		rsp.Warnings = append(rsp.Warnings, g.ds.Warnings...)
+	}
+	if g.loadState.err != nil {
+		rsp.Error = g.loadState.err.Error()
+	}
+	return rsp
+}
+
+// readiness reports whether every game is ready and the server isn't shutting down.
+func (s *Server) readiness() ports.ServerReadinessResponse {
+	s.stateLock.RLock()
+	shuttingDown := s.shuttingDown
+	s.stateLock.RUnlock()
+	rsp := ports.ServerReadinessResponse{
+		Ready:        !shuttingDown,
+		ShuttingDown: shuttingDown,
+		Games:        []*ports.ReadinessResponse{},
+	}
+	for _, g := range s.gameList() {
+		gr := g.readiness()
+		rsp.Ready = rsp.Ready && gr.Ready
+		rsp.Games = append(rsp.Games, gr)
+	}
+	return rsp
+}
+
+// watchData polls each game's galaxy file and reloads the game whenever it changes.
+// Games are only found at startup; adding one needs a restart.
+// It returns when the context is cancelled.
+func (s *Server) watchData(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastTried := make(map[string]time.Time) // don't retry a broken file until it changes again
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, g := range s.gameList() {
+			sb, err := os.Stat(filepath.Join(g.dir, "galaxy.json"))
+			if err != nil {
+				log.Printf("[reload] %+v\n", err)
+				continue
+			}
+			g.dsLock.RLock()
+			changed := !sb.ModTime().Equal(g.dataModTime)
+			g.dsLock.RUnlock()
+			if !changed || sb.ModTime().Equal(lastTried[g.id]) {
+				continue
+			}
+			lastTried[g.id] = sb.ModTime()
+			log.Printf("[reload] %s: %q has changed\n", g.id, sb.Name())
+			if err := g.reload(); err != nil {
+				log.Printf("[reload] %s: %+v\n", g.id, err)
+			}
+		}
+	}
+}
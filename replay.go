@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mdhender/fhdb/config"
+	"github.com/mdhender/fhdb/handlers"
+	"github.com/mdhender/fhdb/jwt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+)
+
+// runReplay sends recorded requests to a server loaded from a data snapshot
+// and reports every response that differs from the recording.
+// Requests that were recorded with a redacted body are skipped.
+func runReplay(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("replay") // -data is the snapshot to replay against
+	requests := fs.String("requests", "", "file of recorded requests")
+	verbose := fs.Bool("verbose", false, "report matching responses as well")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *requests == "" {
+		return fmt.Errorf("must supply the recorded requests file")
+	}
+
+	// the recording only has the subject, so we sign our own tokens with a throwaway key.
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	cfg.Server.JWT.Key = hex.EncodeToString(key)
+	s, err := newServer(cfg)
+	if err != nil {
+		return err
+	}
+	f := jwt.NewFactory(cfg.Server.JWT.Key)
+
+	fd, err := os.Open(*requests)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fd.Close()
+	}()
+
+	var replayed, skipped, differed int
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rr handlers.RecordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &rr); err != nil {
+			return fmt.Errorf("%s:%d: %w", *requests, line, err)
+		}
+
+		if rr.Skip != "" {
+			skipped++
+			if *verbose {
+				fmt.Printf("%s:%d: %s %s: skipped: %s\n", *requests, line, rr.Method, rr.Path, rr.Skip)
+			}
+			continue
+		}
+
+		req := httptest.NewRequest(rr.Method, rr.Path, strings.NewReader(rr.Body))
+		if rr.Subject != nil {
+			token, err := f.NewToken(time.Hour, rr.Subject.Game, rr.Subject.SpeciesId, rr.Subject.Username, "", rr.Subject.Roles...)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", *requests, line, err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler.ServeHTTP(rec, req)
+		replayed++
+
+		var problems []string
+		if rec.Code != rr.Status {
+			problems = append(problems, fmt.Sprintf("status %d, recorded %d", rec.Code, rr.Status))
+		}
+		if hash := handlers.ResponseHash(rec.Body.Bytes()); hash != rr.BodySHA256 {
+			problems = append(problems, fmt.Sprintf("body %.12s, recorded %.12s", hash, rr.BodySHA256))
+		}
+		if problems != nil {
+			differed++
+			fmt.Printf("%s:%d: %s %s: %s\n", *requests, line, rr.Method, rr.Path, strings.Join(problems, ", "))
+		} else if *verbose {
+			fmt.Printf("%s:%d: %s %s: ok\n", *requests, line, rr.Method, rr.Path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("replayed %d requests, %d differed, %d skipped\n", replayed, differed, skipped)
+	if differed != 0 {
+		return fmt.Errorf("%d responses differed from the recording", differed)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mdhender/fhdb/config"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// runReport prints a summary of a game's galaxy,
+// or everything known about one species when -species is given.
+func runReport(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("report")
+	gameId := gameFlag(fs)
+	speciesId := fs.Int("species", 0, "species to report on (default is the galaxy)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 0 {
+		return fmt.Errorf("report: unexpected arguments %q", fs.Args())
+	}
+	dir, err := gameDir(cfg.Data, *gameId)
+	if err != nil {
+		return fmt.Errorf("report: %w", err)
+	}
+	ds, err := loadStore(dir)
+	if err != nil {
+		return err
+	}
+
+	if *speciesId == 0 {
+		stats, err := ds.GetStats()
+		if err != nil {
+			return err
+		} else if *asJSON {
+			return json.NewEncoder(os.Stdout).Encode(stats)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "version\t%s\n", stats.Version)
+		fmt.Fprintf(tw, "turn\t%d\n", stats.TurnNumber)
+		fmt.Fprintf(tw, "systems\t%d\n", stats.Systems)
+		fmt.Fprintf(tw, "planets\t%d\n", stats.Planets)
+		fmt.Fprintf(tw, "species\t%d\n", stats.Species)
+		fmt.Fprintf(tw, "colonies\t%d\n", stats.Colonies)
+		fmt.Fprintf(tw, "ships\t%d\n", stats.Ships)
+		for _, warning := range stats.Warnings {
+			fmt.Fprintf(tw, "warning\t%s\n", warning)
+		}
+		return tw.Flush()
+	}
+
+	sp, err := ds.GetSpeciesDetail(*speciesId)
+	if err != nil {
+		return fmt.Errorf("report: species %d: %w", *speciesId, err)
+	} else if *asJSON {
+		return json.NewEncoder(os.Stdout).Encode(sp)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "species\tSP%02d %s\n", sp.Id, sp.Name)
+	fmt.Fprintf(tw, "government\t%s (%s)\n", sp.Government.Name, sp.Government.Type)
+	fmt.Fprintf(tw, "banked eu\t%d\n", sp.BankedEconomicUnits)
+	fmt.Fprintf(tw, "fleet cost\t%d (%.2f%%)\n", sp.FleetCost, sp.FleetPercentCost)
+	var codes []string
+	for code := range sp.Tech {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		t := sp.Tech[code]
+		fmt.Fprintf(tw, "tech %s\tlevel %d, knowledge %d, xp %d\n", code, t.Level, t.Knowledge, t.BankedXp)
+	}
+	fmt.Fprintf(tw, "allies\t%s\n", speciesList(sp.Allies))
+	fmt.Fprintf(tw, "enemies\t%s\n", speciesList(sp.Enemies))
+	fmt.Fprintf(tw, "neutral\t%s\n", speciesList(sp.Neutral))
+	return tw.Flush()
+}
+
+// speciesList formats species ids as SPnn codes.
+func speciesList(ids []int) string {
+	if len(ids) == 0 {
+		return "none"
+	}
+	var codes []string
+	for _, id := range ids {
+		codes = append(codes, fmt.Sprintf("SP%02d", id))
+	}
+	return strings.Join(codes, " ")
+}
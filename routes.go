@@ -23,43 +23,171 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/mdhender/fhdb/config"
 	"github.com/mdhender/fhdb/handlers"
-	"github.com/mdhender/fhdb/jwt"
+	"github.com/mdhender/fhdb/policy"
+	"github.com/mdhender/fhdb/ports"
+	"github.com/mdhender/fhdb/way"
 	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
 )
 
 // Routes initializes all routes exposed by the Server.
 func (s *Server) Routes(cfg *config.Config) error {
-	for _, route := range []struct {
-		method  string
-		pattern string
-		handler http.HandlerFunc
-	}{
-		{"GET", "/api/calc/mishap/:from/:to/:age/:gv", s.handleCalcMishap()},
-		{"GET", "/api/version", s.handleGetVersion()},
-	} {
-		s.Router.HandleFunc(route.method, route.pattern, route.handler)
-	}
-	for _, route := range []struct {
-		method  string
-		pattern string
-		handler http.HandlerFunc
-	}{
-		{"GET", "/api/flush", s.handleSave()},
-		{"GET", "/api/planet/:id", s.handleGetPlanet()},
-		{"GET", "/api/planets", s.handleGetPlanets()},
-		{"GET", "/api/species", s.handleGetKnownSpecies()},
-		{"GET", "/api/species/:id", s.handleGetSpecies()},
-		{"GET", "/api/system/:id", s.handleGetSystem()},
-		{"GET", "/api/systems", s.handleGetSystems()},
-		{"GET", "/api/turn", s.handleGetTurn()},
-		{"GET", "/api/user", s.handleGetUser()},
-	} {
-		s.Router.HandleFunc(route.method, route.pattern, handlers.Authenticate(route.handler, jwt.NewFactory(cfg.Server.JWT.Key)))
+	public := s.Router.Group("", s.metrics.instrument)
+	public.HandleFunc("GET", "/api/calc/mishap/:from<xyz>/:to<xyz>/:age<uint>/:gv<uint>", s.handleCalcMishap())
+	public.HandleFunc("GET", "/healthz", s.handleGetHealth())
+	public.HandleFunc("GET", "/readyz", s.handleGetReadiness())
+	public.HandleFunc("GET", "/.well-known/jwks.json", s.handleGetJWKS())
+
+	// each game has its own players, so logging in is per game.
+	gamePublic := public.Group("/api/games/:game<"+gameIdExpr+">", s.inGame)
+	gamePublic.HandleFunc("POST", "/login", s.handleLogin())
+	gamePublic.HandleFunc("GET", "/version", s.handleGetVersion())
+
+	// every authenticated write is audited.
+	api := public.Group("/api", s.authenticate, s.track, s.auditedWrites)
+	api.HandleFunc("GET", "/games", s.handleGetGames())
+	api.HandleFunc("POST", "/logout", s.handleLogout())
+	api.HandleFunc("POST", "/token/refresh", s.handleRefreshToken())
+
+	// tokens are scoped to a game, so inGame runs after authenticate.
+	game := api.Group("/games/:game<"+gameIdExpr+">", s.inGame)
+	game.HandleFunc("GET", "/events", s.handleGetEvents())
+	game.HandleFunc("POST", "/messages", s.handlePostMessage())
+	game.HandleFunc("POST", "/orders", s.handlePostOrders())
+	game.HandleFunc("GET", "/planet/:id<.{5,32}>", s.handleGetPlanet())
+	game.HandleFunc("GET", "/planets", s.handleGetPlanets())
+	game.HandleFunc("GET", "/species", s.handleGetKnownSpecies())
+	game.HandleFunc("GET", "/species/:id<int>", s.handleGetSpecies())
+	game.HandleFunc("GET", "/system/:id<xyz>", s.handleGetSystem())
+	game.HandleFunc("GET", "/systems", s.handleGetSystems())
+	game.HandleFunc("GET", "/turn", s.handleGetTurn())
+	game.HandleFunc("GET", "/user", s.handleGetUser())
+
+	// every admin request is audited, including the ones that are denied.
+	// audited runs before authenticate so that bad tokens and failed CSRF checks are recorded too.
+	gameAdmin := public.Group("/api/games/:game<"+gameIdExpr+">/admin", s.audited, s.authenticate, s.track, s.inGame, s.requireAdmin)
+	gameAdmin.HandleFunc("GET", "/audit", s.handleAdminGetAudit())
+	gameAdmin.HandleFunc("POST", "/reload", s.handleAdminReloadGame())
+	gameAdmin.HandleFunc("GET", "/routes", s.handleAdminGetRoutes()) // so GMs who log in to a game can audit the routes
+	gameAdmin.HandleFunc("POST", "/save", s.handleAdminSave())
+	gameAdmin.HandleFunc("GET", "/species/:id<int>", s.handleAdminGetSpecies())
+	gameAdmin.HandleFunc("GET", "/stats", s.handleAdminGetStats())
+	gameAdmin.HandleFunc("PUT", "/turn", s.handleAdminSetTurn())
+
+	// the server's admin routes cover every game, so they need a server-wide token.
+	admin := public.Group("/api/admin", s.audited, s.authenticate, s.track, s.requireAdmin, s.requireServerWide)
+	admin.HandleFunc("POST", "/reload", s.handleAdminReload())
+	admin.HandleFunc("GET", "/routes", s.handleAdminGetRoutes())
+	admin.HandleFunc("GET", "/sessions", s.handleAdminGetSessions())
+
+	if cfg.Server.Metrics.Serve {
+		s.Router.HandleFunc("GET", cfg.Server.Metrics.Path, s.handleGetMetrics())
 	}
+	s.Router.NotFound = s.metrics.instrument(s.Router.NotFound)
+	s.Router.MethodNotAllowed = s.metrics.instrument(s.Router.MethodNotAllowed)
 	//s.Router.NotFound = handlers.Static("/", cfg.Server.Web.Root, true, true)
 	return nil
 }
 
-// 	s.Handler = mwCORS(handlers.Authenticate(mwVersion(s.Router, s.jdb.Version), jwt.NewFactory(cfg.Server.JWT.Key)))
+// 	s.Handler = mwCORS(handlers.Authenticate(mwVersion(s.Router, s.jdb.Version), s.tokens))
+
+// Marks that the middleware reports to the router. routeTable uses them
+// to work out the authentication that each route really requires.
+const (
+	markAuthenticate = "authenticate"
+	markInGame       = "in-game"
+	markAdmin        = "admin"
+	markServerWide   = "server-wide"
+)
+
+// authenticate is the middleware form of handlers.Authenticate.
+func (s *Server) authenticate(h http.Handler) http.Handler {
+	return way.Mark(handlers.Authenticate(h.ServeHTTP, s.tokens), markAuthenticate)
+}
+
+// track is the middleware form of the session tracker.
+func (s *Server) track(h http.Handler) http.Handler {
+	return s.sessions.Track(h.ServeHTTP)
+}
+
+// requireAdmin is the middleware form of handlers.RequireScope for the admin scope.
+func (s *Server) requireAdmin(h http.Handler) http.Handler {
+	return way.Mark(handlers.RequireScope(h.ServeHTTP, policy.Admin), markAdmin)
+}
+
+// routeTable describes the registered routes and the authentication each one requires.
+// The authentication comes from the marks that the middleware reported when the
+// route was added, so a route only needs a token if handlers.Authenticate really
+// wraps it. The game, admin, and server-wide checks only count when they run after
+// Authenticate, since before it there is no session for them to check.
+// Game routes need a token for the game in the path; server admin routes need
+// an admin token that isn't scoped to a game.
+func (s *Server) routeTable() []*ports.AdminRouteResponse {
+	var list []*ports.AdminRouteResponse
+	for _, ri := range s.Router.Routes() {
+		rt := &ports.AdminRouteResponse{
+			Method:     ri.Method,
+			Pattern:    ri.Pattern,
+			Middleware: ri.Middleware,
+			Auth:       "public",
+		}
+		if rt.Middleware == nil {
+			rt.Middleware = []string{}
+		}
+		for _, mark := range ri.Marks {
+			switch {
+			case mark == markAuthenticate && rt.Auth == "public":
+				rt.Auth = "token"
+			case mark == markInGame && rt.Auth == "token":
+				rt.Auth = "game token"
+			case mark == markAdmin && rt.Auth != "public":
+				rt.Auth = "admin"
+			case mark == markServerWide && rt.Auth == "admin":
+				rt.Auth = "server admin"
+			}
+		}
+		list = append(list, rt)
+	}
+	return list
+}
+
+// runRoutes prints the routes the server would register.
+// The store isn't loaded, so the data folder doesn't need to exist.
+func runRoutes(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("routes")
+	metricsServe := fs.Bool("metrics", cfg.Server.Metrics.Serve, "include the metrics route")
+	metricsPath := fs.String("metrics-path", cfg.Server.Metrics.Path, "path to serve metrics on")
+	asJSON := fs.Bool("json", false, "print the routes as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 0 {
+		return fmt.Errorf("routes: unexpected arguments %q", fs.Args())
+	}
+	cfg.Server.Metrics.Serve = *metricsServe
+	cfg.Server.Metrics.Path = *metricsPath
+
+	s := &Server{Router: way.NewRouter(), metrics: newServerMetrics()}
+	if err := s.Routes(cfg); err != nil {
+		return err
+	}
+	routes := s.routeTable()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(routes)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "METHOD\tPATTERN\tAUTH\tMIDDLEWARE\n")
+	for _, rt := range routes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", rt.Method, rt.Pattern, rt.Auth, strings.Join(rt.Middleware, " > "))
+	}
+	return tw.Flush()
+}
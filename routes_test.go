@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"github.com/mdhender/fhdb/config"
+	"github.com/mdhender/fhdb/way"
+	"strings"
+	"testing"
+)
+
+// publicRoutes are the only routes that may be served without a token.
+var publicRoutes = map[string]bool{
+	"GET /api/calc/mishap/:from<xyz>/:to<xyz>/:age<uint>/:gv<uint>": true,
+	"GET /healthz":               true,
+	"GET /readyz":                true,
+	"GET /.well-known/jwks.json": true,
+	"POST /api/games/:game<" + gameIdExpr + ">/login":  true,
+	"GET /api/games/:game<" + gameIdExpr + ">/version": true,
+	"GET /metrics": true,
+}
+
+// TestRoutesRequireAuth fails if a route that isn't listed as public can be
+// reached without going through handlers.Authenticate, or if an admin route
+// doesn't check for the admin scope.
+func TestRoutesRequireAuth(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.Metrics.Serve = true
+	s := &Server{Router: way.NewRouter(), metrics: newServerMetrics()}
+	if err := s.Routes(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, rt := range s.routeTable() {
+		key := rt.Method + " " + rt.Pattern
+		switch {
+		case publicRoutes[key]:
+			if rt.Auth != "public" {
+				t.Errorf("%s: want public, got %q", key, rt.Auth)
+			}
+		case rt.Auth == "public":
+			t.Errorf("%s: not listed as public but has no authentication middleware", key)
+		case strings.HasPrefix(rt.Pattern, "/api/admin/"):
+			if rt.Auth != "server admin" {
+				t.Errorf("%s: want server admin, got %q", key, rt.Auth)
+			}
+		case strings.Contains(rt.Pattern, "/admin/"):
+			if rt.Auth != "admin" {
+				t.Errorf("%s: want admin, got %q", key, rt.Auth)
+			}
+		case strings.HasPrefix(rt.Pattern, "/api/games/"):
+			if rt.Auth != "game token" {
+				t.Errorf("%s: want game token, got %q", key, rt.Auth)
+			}
+		}
+	}
+}
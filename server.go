@@ -23,14 +23,22 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/mdhender/fhdb/audit"
 	"github.com/mdhender/fhdb/handlers"
+	"github.com/mdhender/fhdb/jwt"
 	"github.com/mdhender/fhdb/ports"
 	"github.com/mdhender/fhdb/store/memory"
 	"github.com/mdhender/fhdb/way"
+	"log"
+	"net"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 )
 
 type Server struct {
@@ -41,94 +49,193 @@ type Server struct {
 	Templates struct {
 		Root string
 	}
-	debug bool
-	ds    *memory.Store
+	Events struct {
+		Heartbeat time.Duration // interval between heartbeats on event streams
+	}
+	debug          bool
+	games          map[string]*game // by id; only changes at startup
+	audit          *audit.Log       // for requests that aren't for a game
+	metrics        *serverMetrics
+	revocations    *jwt.RevocationList
+	loginLimiter   *handlers.RateLimiter
+	sessions       *handlers.SessionTracker
+	tokens         jwt.Factory  // signs tokens issued by the login endpoint
+	keys           *jwt.Keyring // shared with tokens when keys are loaded from keyFile
+	keyFile        string
+	tokenTTL       time.Duration // lifetime of tokens issued by the login endpoint
+	cookieSameSite http.SameSite // for the session and CSRF cookies
+	stateLock      sync.RWMutex
+	shuttingDown   bool
+}
+
+// connContextKey is the context key for the request's network connection.
+// It is set by connContext.
+type connContextKey struct{}
+
+// connContext adds the network connection to the context of its requests
+// so that long-running handlers can manage their own write deadlines.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
 }
 
 func (s *Server) handleCalcMishap() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fields := strings.Split(way.Param(r.Context(), "from"), " ")
-		if len(fields) != 3 {
+		// the route constrains the parameters, so these only fail if the route changes.
+		from, ok := way.ParamXYZ(r.Context(), "from")
+		if !ok {
 			http.Error(w, "invalid from", http.StatusBadRequest)
-		}
-		fromX, err := strconv.Atoi(fields[0])
-		if err != nil {
-			http.Error(w, "invalid from.x", http.StatusBadRequest)
 			return
 		}
-		fromY, err := strconv.Atoi(fields[1])
-		if err != nil {
-			http.Error(w, "invalid from.y", http.StatusBadRequest)
+		to, ok := way.ParamXYZ(r.Context(), "to")
+		if !ok {
+			http.Error(w, "invalid to", http.StatusBadRequest)
 			return
 		}
-		fromZ, err := strconv.Atoi(fields[2])
-		if err != nil {
-			http.Error(w, "invalid from.z", http.StatusBadRequest)
+		mishapAge, ok := way.ParamInt(r.Context(), "age")
+		if !ok || mishapAge < 0 {
+			http.Error(w, "age must be a non-negative integer", http.StatusBadRequest)
 			return
 		}
-		fields = strings.Split(way.Param(r.Context(), "to"), " ")
-		if len(fields) != 3 {
-			http.Error(w, "invalid to", http.StatusBadRequest)
-		}
-		toX, err := strconv.Atoi(fields[0])
-		if err != nil {
-			http.Error(w, "invalid to.x", http.StatusBadRequest)
+		mishapGV, ok := way.ParamInt(r.Context(), "gv")
+		if !ok || mishapGV < 1 {
+			http.Error(w, "gv must be a positive integer", http.StatusBadRequest)
 			return
 		}
-		toY, err := strconv.Atoi(fields[1])
-		if err != nil {
-			http.Error(w, "invalid to.y", http.StatusBadRequest)
+		rsp := calcMishap(from, to, mishapAge, mishapGV)
+		jsonOk(w, r, rsp)
+	}
+}
+
+// stream changes to the caller's species as server-sent events.
+// the stream stays open until the client leaves or the server shuts down;
+// clients reconnect and send Last-Event-ID to pick up anything they missed.
+func (s *Server) handleGetEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess := handlers.GetSession(r)
+		if sess == nil || !sess.Authenticated {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		toZ, err := strconv.Atoi(fields[2])
-		if err != nil {
-			http.Error(w, "invalid to.z", http.StatusBadRequest)
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
 			return
 		}
-		mishapAge, err := strconv.Atoi(way.Param(r.Context(), "age"))
-		if err != nil {
-			http.Error(w, "invalid age", http.StatusBadRequest)
-			return
-		} else if mishapAge < 0 {
-			http.Error(w, "age must be a non-negative integer", http.StatusBadRequest)
-			return
+		var lastEventId int64
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			var err error
+			if lastEventId, err = strconv.ParseInt(id, 10, 64); err != nil {
+				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
 		}
-		mishapGV, err := strconv.Atoi(way.Param(r.Context(), "gv"))
-		if err != nil {
-			http.Error(w, "invalid gv", http.StatusBadRequest)
-			return
-		} else if mishapGV < 1 {
-			http.Error(w, "gv must be a positive integer", http.StatusBadRequest)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no") // don't let proxies buffer the stream
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusOK) // the router sends HEAD here; don't hold the connection open
 			return
 		}
-		deltaX := fromX - toX
-		deltaY := fromY - toY
-		deltaZ := fromZ - toZ
-		mishapChance := 100 * (deltaX*deltaX + deltaY*deltaY + deltaZ*deltaZ) / mishapGV
 
-		if mishapChance > 10000 {
-			mishapChance = 10000
-		} else if mishapAge > 0 {
-			// Add aging effect
-			successChance := 10000 - mishapChance
-			successChance -= (2 * mishapAge * successChance) / 100
-			if successChance < 0 {
-				successChance = 0
+		g := getGame(r)
+		sub, missed := g.events.Subscribe(sess.SpeciesId, lastEventId)
+		defer g.events.Unsubscribe(sub)
+
+		heartbeat := time.NewTicker(s.Events.Heartbeat)
+		defer heartbeat.Stop()
+
+		// the server's write timeout would cut the stream off, so the deadline is
+		// pushed back before every write, far enough to cover the wait for the next
+		// heartbeat. without the connection (as in replays), the stream is closed
+		// before the server's deadline expires.
+		conn, _ := r.Context().Value(connContextKey{}).(net.Conn)
+		extend := func() {
+			if conn != nil && s.WriteTimeout > 0 {
+				_ = conn.SetWriteDeadline(time.Now().Add(s.Events.Heartbeat + s.WriteTimeout))
 			}
-			mishapChance = 10000 - successChance
-		}
-		rsp := ports.MishapResponse{
-			Age:          mishapAge,
-			GV:           mishapGV,
-			MishapChance: float64(mishapChance) / 100,
-		}
-		rsp.From.X = fromX
-		rsp.From.Y = fromY
-		rsp.From.Z = fromZ
-		rsp.To.X = toX
-		rsp.To.Y = toY
-		rsp.To.Z = toZ
-		jsonOk(w, r, rsp)
+		}
+		extend()
+		var expired <-chan time.Time
+		if conn == nil && s.WriteTimeout > 0 {
+			lifetime := s.WriteTimeout - time.Second
+			if lifetime < time.Second {
+				lifetime = time.Second
+			}
+			timer := time.NewTimer(lifetime)
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, "retry: %d\n\n", 2000)
+		for _, e := range missed {
+			if _, err := e.WriteTo(w); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-expired:
+				return
+			case <-heartbeat.C:
+				extend()
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				extend()
+				if _, err := e.WriteTo(w); err != nil {
+					log.Printf("[events] %+v\n", err)
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// report that the process is alive. this never checks the store.
+func (s *Server) handleGetHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonStatus(w, http.StatusOK, ports.HealthResponse{Status: "ok"})
+	}
+}
+
+// report whether every game is loaded and the server can take traffic.
+func (s *Server) handleGetReadiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rsp := s.readiness()
+		if !rsp.Ready {
+			jsonStatus(w, http.StatusServiceUnavailable, rsp)
+			return
+		}
+		jsonStatus(w, http.StatusOK, rsp)
+	}
+}
+
+// publish the public keys that verify our tokens.
+// HS256 secrets are never published, so the set is empty without asymmetric keys.
+func (s *Server) handleGetJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		set := jwt.JWKS{Keys: []jwt.JWK{}}
+		if s.keys != nil {
+			set = s.keys.JWKS(time.Now())
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(set); err != nil {
+			log.Printf("%s: error writing response: %+v\n", r.URL.Path, err)
+		}
 	}
 }
 
@@ -139,7 +246,7 @@ func (s *Server) handleGetKnownSpecies() http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		rsp, err := s.ds.GetKnownSpecies(sess.SpeciesId, sess.Roles)
+		rsp, err := getGame(r).store().GetKnownSpecies(sess.Principal)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 			return
@@ -150,15 +257,11 @@ func (s *Server) handleGetKnownSpecies() http.HandlerFunc {
 
 func (s *Server) handleGetPlanet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if s.ds == nil {
+		if getGame(r).store() == nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		id := way.Param(r.Context(), "id")
-		if len(id) < 5 || len(id) > 32 {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-			return
-		}
+		// the route limits the id to 5 to 32 characters.
 		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
 		//planet, ok := s.ds.Planets[id]
 		//if !ok {
@@ -198,7 +301,7 @@ func (s *Server) handleGetPlanet() http.HandlerFunc {
 
 func (s *Server) handleGetPlanets() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if s.ds == nil {
+		if getGame(r).store() == nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
@@ -235,11 +338,12 @@ func (s *Server) handleGetSpecies() http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		id, err := strconv.Atoi(way.Param(r.Context(), "id"))
-		if err != nil {
+		id, ok := way.ParamInt(r.Context(), "id")
+		if !ok {
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
 		}
-		rsp, err := s.ds.GetSpecies(id, sess.Roles)
+		rsp, err := getGame(r).store().GetSpecies(id, sess.Principal)
 		if err != nil {
 			if errors.Is(err, ports.ErrUnauthorized) {
 				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
@@ -259,10 +363,19 @@ func (s *Server) handleGetSystem() http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		id := way.Param(r.Context(), "id")
-		rsp, err := s.ds.GetSystem(id, sess.SpeciesId)
+		at, ok := way.ParamXYZ(r.Context(), "id")
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		rsp, err := getGame(r).store().GetSystem(fmt.Sprintf("%d %d %d", at.X, at.Y, at.Z), sess.SpeciesId)
 		if err != nil {
+			if errors.Is(err, ports.ErrNotFound) {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 		jsonOk(w, r, rsp)
 	}
@@ -275,9 +388,11 @@ func (s *Server) handleGetSystems() http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		rsp, err := s.ds.GetSystems(sess.SpeciesId)
+		g := getGame(r)
+		rsp, err := g.store().GetSystems(sess.SpeciesId, g.route())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 		jsonOk(w, r, rsp)
 	}
@@ -290,7 +405,7 @@ func (s *Server) handleGetTurn() http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		rsp, err := s.ds.GetTurnNumber()
+		rsp, err := getGame(r).store().GetTurnNumber()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -305,7 +420,7 @@ func (s *Server) handleGetUser() http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		rsp, err := s.ds.GetUser(sess.SpeciesId)
+		rsp, err := getGame(r).store().GetUser(sess.SpeciesId)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -315,7 +430,7 @@ func (s *Server) handleGetUser() http.HandlerFunc {
 
 func (s *Server) handleGetVersion() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rsp, err := s.ds.GetVersion()
+		rsp, err := getGame(r).store().GetVersion()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -323,17 +438,6 @@ func (s *Server) handleGetVersion() http.HandlerFunc {
 	}
 }
 
-func (s *Server) handleSave() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if s.ds == nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-		s.ds.Write(s.Data)
-		w.WriteHeader(http.StatusOK)
-	}
-}
-
 type jCoords struct {
 	X int `json:"x"`
 	Y int `json:"y"`
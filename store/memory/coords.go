@@ -32,7 +32,7 @@ type Coords struct {
 func (c Coords) Less(t Coords) bool {
 	if c.X < t.X {
 		return true
-	} else if c.X == c.X {
+	} else if c.X == t.X {
 		if c.Y < t.Y {
 			return true
 		} else if c.Y == t.Y {
@@ -31,6 +31,9 @@ import (
 func (ds *Store) Read(jdb *jsondb.Store) error {
 	log.Printf("reading json store\n")
 	ds.Version = jdb.Version
+	if jdb.Galaxy != nil {
+		ds.TurnNumber = jdb.Galaxy.TurnNumber
+	}
 
 	var maxPlanetId int
 	for _, planet := range jdb.Planets {
@@ -192,6 +195,22 @@ func (ds *Store) Read(jdb *jsondb.Store) error {
 			BankedXp:  species.Tech.Military.BankedXp,
 		}
 		ds.Species[sp.Id] = &sp
+		hw := species.Homeworld.Coords
+		if _, ok := ds.Systems[fmt.Sprintf("%d %d %d", hw.X, hw.Y, hw.Z)]; !ok {
+			ds.Warnings = append(ds.Warnings, fmt.Sprintf("species %d: homeworld system %d %d %d not found", sp.Id, hw.X, hw.Y, hw.Z))
+		}
+	}
+
+	if ds.TurnNumber == 0 {
+		ds.Warnings = append(ds.Warnings, "turn number is zero")
+	}
+	for _, planet := range ds.Planets {
+		if planet != nil && planet.System == nil {
+			ds.Warnings = append(ds.Warnings, fmt.Sprintf("planet %d: not in any system", planet.Id))
+		}
+	}
+	for _, w := range ds.Warnings {
+		log.Printf("warning: %s\n", w)
 	}
 
 	return nil
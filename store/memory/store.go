@@ -24,7 +24,9 @@ package memory
 
 import (
 	"fmt"
+	"github.com/mdhender/fhdb/policy"
 	"github.com/mdhender/fhdb/ports"
+	"sort"
 )
 
 type Store struct {
@@ -37,21 +39,27 @@ type Store struct {
 
 	Colonies map[string]*Colony // colonies are "named planets"
 	Ships    map[string]*Ship   // key for ship is spId / shipId
+
+	Warnings []string // problems found while reading that don't prevent loading
 }
 
-func (ds *Store) GetKnownSpecies(id int, roles map[string]bool) ([]*ports.KnownSpeciesResponse, error) {
+// GetKnownSpecies returns the species that the principal knows,
+// which are the ones named by its "SPnn" roles. Admins know every species.
+func (ds *Store) GetKnownSpecies(p policy.Principal) ([]*ports.KnownSpeciesResponse, error) {
 	if ds == nil {
 		return nil, ports.ErrInternalError
-	} else if len(roles) == 0 {
+	} else if err := policy.Check(p, policy.ReadSelf, p.SpeciesId, ds); err != nil {
 		return nil, ports.ErrUnauthorized
-	} else if id < 1 || !(id < len(ds.Species)) {
+	} else if !p.IsAdmin() && (p.SpeciesId < 1 || !(p.SpeciesId < len(ds.Species))) {
 		return nil, ports.ErrUnauthorized
 	}
 	var results []*ports.KnownSpeciesResponse
 	for _, v := range ds.Species {
-		if id == v.Id { // don't report on self
+		if v == nil { // species are indexed by id, so there are gaps
+			continue
+		} else if v.Id == p.SpeciesId { // don't report on self
 			continue
-		} else if _, ok := roles[fmt.Sprintf("SP%02d", v.Id)]; !ok {
+		} else if !p.IsAdmin() && !p.Known[v.Id] {
 			continue
 		}
 		sp := ports.KnownSpeciesResponse{
@@ -65,12 +73,11 @@ func (ds *Store) GetKnownSpecies(id int, roles map[string]bool) ([]*ports.KnownS
 	return results, nil
 }
 
-func (ds *Store) GetSpecies(id int, roles map[string]bool) (*ports.SpeciesResponse, error) {
+// GetSpecies returns a species if the principal may read it.
+func (ds *Store) GetSpecies(id int, p policy.Principal) (*ports.SpeciesResponse, error) {
 	if ds == nil {
 		return nil, ports.ErrInternalError
-	} else if len(roles) == 0 {
-		return nil, ports.ErrUnauthorized
-	} else if _, ok := roles[fmt.Sprintf("SP%02d", id)]; !ok {
+	} else if err := policy.Check(p, policy.ReadScope(p, id), id, ds); err != nil {
 		return nil, ports.ErrUnauthorized
 	} else if id < 1 || !(id < len(ds.Species)) {
 		return nil, ports.ErrUnauthorized
@@ -85,6 +92,85 @@ func (ds *Store) GetSpecies(id int, roles map[string]bool) (*ports.SpeciesRespon
 	return &rsp, nil
 }
 
+// GetSpeciesDetail returns everything known about a species.
+// It does not check permissions; callers must only use it for game masters.
+func (ds *Store) GetSpeciesDetail(id int) (*ports.AdminSpeciesResponse, error) {
+	if ds == nil {
+		return nil, ports.ErrInternalError
+	} else if id < 1 || !(id < len(ds.Species)) || ds.Species[id] == nil {
+		return nil, ports.ErrNotFound
+	}
+	sp := ds.Species[id]
+	rsp := ports.AdminSpeciesResponse{
+		Id:                  sp.Id,
+		Name:                sp.Name,
+		AutoOrders:          sp.AutoOrders,
+		BankedEconomicUnits: sp.BankedEconomicUnits,
+		FleetCost:           sp.FleetCost,
+		FleetPercentCost:    sp.FleetPercentCost,
+		Tech:                make(map[string]*ports.TechResponse),
+		Allies:              []int{},
+		Enemies:             []int{},
+		Neutral:             []int{},
+	}
+	rsp.Government.Name = sp.Government.Name
+	rsp.Government.Type = sp.Government.Type
+	for code, t := range sp.Tech {
+		rsp.Tech[code] = &ports.TechResponse{Level: t.Level, Init: t.Init, Knowledge: t.Knowledge, BankedXp: t.BankedXp}
+	}
+	for alien := 1; alien < len(ds.Species); alien++ {
+		switch sp.Relationships[alien] {
+		case Ally:
+			rsp.Allies = append(rsp.Allies, alien)
+		case Enemy:
+			rsp.Enemies = append(rsp.Enemies, alien)
+		case Neutral:
+			rsp.Neutral = append(rsp.Neutral, alien)
+		}
+	}
+	return &rsp, nil
+}
+
+// GetStats returns the size of the store.
+func (ds *Store) GetStats() (*ports.StoreStatsResponse, error) {
+	if ds == nil {
+		return nil, ports.ErrInternalError
+	}
+	rsp := ports.StoreStatsResponse{
+		Version:    ds.Version,
+		TurnNumber: ds.TurnNumber,
+		Systems:    len(ds.Systems),
+		Colonies:   len(ds.Colonies),
+		Ships:      len(ds.Ships),
+		Warnings:   append([]string{}, ds.Warnings...),
+	}
+	for _, p := range ds.Planets {
+		if p != nil {
+			rsp.Planets++
+		}
+	}
+	for _, sp := range ds.Species {
+		if sp != nil {
+			rsp.Species++
+		}
+	}
+	return &rsp, nil
+}
+
+// IsSpecies returns true if the store has a species with the id.
+func (ds *Store) IsSpecies(id int) bool {
+	return ds != nil && id > 0 && id < len(ds.Species) && ds.Species[id] != nil
+}
+
+// IsAlly implements the policy.Relations interface.
+// It returns true if species of has declared species with to be an ally.
+func (ds *Store) IsAlly(of, with int) bool {
+	if ds == nil || of < 1 || !(of < len(ds.Species)) || ds.Species[of] == nil {
+		return false
+	}
+	return ds.Species[of].Relationships[with] == Ally
+}
+
 func (ds *Store) GetSystem(id string, spId int) (*ports.SystemResponse, error) {
 	if ds == nil {
 		return nil, ports.ErrInternalError
@@ -100,17 +186,27 @@ func (ds *Store) GetSystem(id string, spId int) (*ports.SystemResponse, error) {
 	return &rsp, nil
 }
 
-func (ds *Store) GetSystems(spId int) ([]*ports.SystemsResponse, error) {
+// GetSystems returns every system. Links are relative to the prefix,
+// which is the route of the game the store holds, like "/api/games/alpha".
+func (ds *Store) GetSystems(spId int, prefix string) ([]*ports.SystemsResponse, error) {
 	if ds == nil {
 		return nil, ports.ErrInternalError
 	}
 	//speciesId := fmt.Sprintf("SP%02d", spId)
-	var systems []*ports.SystemsResponse
+	// sort the systems so that responses are stable from one request to the next
+	var sorted []*System
 	for _, v := range ds.Systems {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Less(sorted[j])
+	})
+	var systems []*ports.SystemsResponse
+	for _, v := range sorted {
 		system := ports.SystemsResponse{
 			Id:     v.Id,
-			Coords: ports.Coords{v.Coords.X, v.Coords.Y, v.Coords.Z},
-			Link:   fmt.Sprintf("/api/system/%d %d %d", v.Coords.X, v.Coords.Y, v.Coords.Z),
+			Coords: ports.Coords{X: v.Coords.X, Y: v.Coords.Y, Z: v.Coords.Z},
+			Link:   fmt.Sprintf("%s/system/%d %d %d", prefix, v.Coords.X, v.Coords.Y, v.Coords.Z),
 		}
 		//for _, sp := range v.VisitedBy {
 		//	if sp == speciesId {
@@ -140,7 +236,7 @@ func (ds *Store) GetUser(spId int) (*ports.UserResponse, error) {
 		return nil, ports.ErrInternalError
 	}
 	for _, sp := range ds.Species {
-		if spId == sp.Id {
+		if sp != nil && spId == sp.Id {
 			return &ports.UserResponse{
 				Id: sp.Id,
 			}, nil
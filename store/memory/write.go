@@ -22,10 +22,12 @@
 
 package memory
 
-import "log"
+import "github.com/mdhender/fhdb/ports"
 
+// Write is meant to save the store to the root folder.
+// Saving hasn't been implemented, so it always returns ports.ErrNotImplemented
+// and nothing is written.
 func (ds *Store) Write(root string) error {
-	log.Printf("not saving %q\n", root)
 	//filename := filepath.Join(root, "wstore.json")
 	//
 	//// convert in-memory structures to json-file structures
@@ -98,7 +100,7 @@ func (ds *Store) Write(root string) error {
 	//}
 	//
 	//log.Printf("saved %6d systems\n", len(data.Systems))
-	return nil
+	return ports.ErrNotImplemented
 }
 
 type wSpecies struct {
@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/mdhender/fhdb/config"
+	"github.com/mdhender/fhdb/jwt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runToken mints and inspects tokens with the configured keys.
+func runToken(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fhdb token (issue|inspect) [options]")
+	}
+	switch args[0] {
+	case "issue":
+		return runTokenIssue(args[1:])
+	case "inspect":
+		return runTokenInspect(args[1:])
+	}
+	return fmt.Errorf("token: unknown command %q", args[0])
+}
+
+// runTokenIssue prints a new token for a species.
+// Tokens without a game are server-wide; only they can use the server's admin routes.
+func runTokenIssue(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("token issue")
+	gameId := fs.String("game", "", "game the token is for (omit for a server-wide token)")
+	speciesId := fs.Int("species", 0, "species id for the token")
+	username := fs.String("username", "", "username for the token (optional)")
+	email := fs.String("email", "", "email for the token (optional)")
+	roles := fs.String("roles", "", "comma separated list of roles")
+	ttl := fs.Duration("ttl", 0, "lifetime of the token (default jwt-ttl)")
+	if err := parseTokenFlags(fs, args, cfg); err != nil {
+		return err
+	}
+	if *ttl == 0 {
+		*ttl = cfg.Server.JWT.TTL
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("token issue: unexpected arguments %q", fs.Args())
+	} else if *gameId != "" && !validGameId.MatchString(*gameId) {
+		return fmt.Errorf("token issue: %q is not a valid game id", *gameId)
+	} else if *speciesId < 0 {
+		return fmt.Errorf("token issue: species must not be negative")
+	} else if *ttl <= 0 {
+		return fmt.Errorf("token issue: ttl must be a positive duration")
+	}
+	var roleList []string
+	for _, role := range strings.Split(*roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roleList = append(roleList, role)
+		}
+	}
+
+	f, _, err := newTokenFactory(cfg, nil)
+	if err != nil {
+		return err
+	}
+	token, err := f.NewToken(*ttl, *gameId, *speciesId, *username, *email, roleList...)
+	if err != nil {
+		return fmt.Errorf("token issue: %w", err)
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// runTokenInspect decodes a token and explains whether the server would accept it.
+// The token is read from stdin when the argument is "-".
+func runTokenInspect(args []string) error {
+	cfg := config.Default()
+	fs := cfg.NewFlagSet("token inspect")
+	if err := parseTokenFlags(fs, args, cfg); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: fhdb token inspect [options] (<jwt>|-)")
+	}
+	token := fs.Arg(0)
+	if token == "-" {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		token = string(b)
+	}
+	token = strings.TrimPrefix(strings.TrimSpace(token), "Bearer ")
+
+	// show the header and claims even if the token doesn't parse.
+	sections := strings.SplitN(token, ".", 3)
+	for i, name := range []string{"header", "claims"} {
+		if i >= len(sections) {
+			break
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(sections[i])
+		if err != nil {
+			fmt.Printf("%s: not base64: %v\n", name, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, raw, "  ", "  "); err != nil {
+			fmt.Printf("%s: not json: %v\n", name, err)
+			continue
+		}
+		fmt.Printf("%s:\n  %s\n", name, buf.String())
+	}
+
+	j, err := jwt.Parse(token)
+	if err != nil {
+		return fmt.Errorf("token rejected: %w", err)
+	}
+	now := time.Now().UTC()
+	fmt.Printf("issued:    %s (%s)\n", j.IssuedAt().Format(time.RFC3339), relative(j.IssuedAt(), now))
+	fmt.Printf("expires:   %s (%s)\n", j.ExpiresAt().Format(time.RFC3339), relative(j.ExpiresAt(), now))
+
+	rl, err := jwt.LoadRevocationList(filepath.Join(cfg.Data, "revoked.json"), cfg.Server.JWT.Leeway)
+	if err != nil {
+		return err
+	}
+	f, _, err := newTokenFactory(cfg, rl)
+	if err != nil {
+		return err
+	}
+
+	var reasons []string
+	if err := f.Verify(j); err != nil {
+		fmt.Printf("signature: rejected: %v\n", err)
+		reasons = append(reasons, "signature: "+err.Error())
+	} else {
+		fmt.Printf("signature: ok\n")
+	}
+	if err := j.CheckClaims(f.Claims(), now); err != nil {
+		fmt.Printf("claims:    rejected: %v\n", err)
+		reasons = append(reasons, "claims: "+err.Error())
+	} else {
+		fmt.Printf("claims:    ok\n")
+	}
+	if j.Id() == "" {
+		fmt.Printf("revoked:   no (token has no id and can't be revoked)\n")
+	} else if f.IsRevoked(j) {
+		fmt.Printf("revoked:   yes\n")
+		reasons = append(reasons, jwt.ErrRevoked.Error())
+	} else {
+		fmt.Printf("revoked:   no\n")
+	}
+
+	if len(reasons) != 0 {
+		return fmt.Errorf("token rejected: %s", strings.Join(reasons, "; "))
+	}
+	fmt.Printf("token would be accepted\n")
+	return nil
+}
+
+// parseTokenFlags adds the flags that configure the token factory and parses the arguments.
+// The data folder is used to find revoked tokens.
+func parseTokenFlags(fs *config.FlagSet, args []string, cfg *config.Config) error {
+	fs.JWTFlags()
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Server.JWT.KeyFile == "" && len(cfg.Server.JWT.Key) < 16 {
+		return fmt.Errorf("jwt key length should be at least 16")
+	}
+	return nil
+}
+
+// relative describes a time relative to now, like "in 3h0m0s" or "5m0s ago".
+func relative(t, now time.Time) string {
+	d := t.Sub(now).Round(time.Second)
+	if d < 0 {
+		return (-d).String() + " ago"
+	}
+	return "in " + d.String()
+}
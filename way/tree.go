@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package way
+
+import (
+	"strings"
+)
+
+// node is a node in the routing tree. Each level of the tree matches one
+// segment of the path. When more than one child could match a segment,
+// they are tried in priority order:
+//  1. static segments, like /api
+//  2. parameters, like /:id, in the order they were registered
+//  3. partial segments, like /img...
+//  4. prefix routes ending at the node, like /static/
+//
+// If a branch matches the path but has no route for the method,
+// the search backs up and tries the next branch.
+type node struct {
+	static   map[string]*node
+	params   []*paramNode
+	partials []*partialNode
+	routes   []*route // routes that end at this node
+	prefixes []*route // prefix routes that end at this node
+}
+
+// paramNode matches any segment that satisfies the check.
+type paramNode struct {
+	key   string // the segment from the pattern, including the constraint
+	name  string
+	check func(string) bool // nil if there is no constraint
+	child *node
+}
+
+// partialNode matches segments that start with the prefix.
+// Everything after the segment is ignored.
+type partialNode struct {
+	prefix string
+	routes []*route
+}
+
+// param is a parameter captured while searching the tree.
+type param struct {
+	name, value string
+}
+
+// insert adds the route to the tree.
+func (n *node) insert(rt *route, segs []string) {
+	for _, seg := range segs {
+		if !strings.HasPrefix(seg, ":") && strings.HasSuffix(seg, "...") {
+			prefix := seg[:len(seg)-3]
+			for _, pn := range n.partials {
+				if pn.prefix == prefix {
+					pn.routes = append(pn.routes, rt)
+					return
+				}
+			}
+			n.partials = append(n.partials, &partialNode{prefix: prefix, routes: []*route{rt}})
+			return
+		} else if strings.HasPrefix(seg, ":") {
+			var child *node
+			for _, pn := range n.params {
+				if pn.key == seg {
+					child = pn.child
+					break
+				}
+			}
+			if child == nil {
+				pn := &paramNode{key: seg, name: seg[1:], child: &node{}}
+				if i := strings.Index(seg, "<"); i != -1 && strings.HasSuffix(seg, ">") {
+					pn.name, pn.check = seg[1:i], constraint(seg[i+1:len(seg)-1])
+				}
+				n.params = append(n.params, pn)
+				child = pn.child
+			}
+			n = child
+			continue
+		}
+		if n.static == nil {
+			n.static = make(map[string]*node)
+		}
+		child, ok := n.static[seg]
+		if !ok {
+			child = &node{}
+			n.static[seg] = child
+		}
+		n = child
+	}
+	if rt.prefix {
+		n.prefixes = append(n.prefixes, rt)
+	} else {
+		n.routes = append(n.routes, rt)
+	}
+}
+
+// lookup returns the first route, in priority order, that matches the path
+// and is accepted by the match function. The parameters captured along the
+// way are appended to params.
+func (n *node) lookup(segs []string, params []param, match func(*route) bool) (*route, []param) {
+	if len(segs) == 0 {
+		if rt := pick(n.routes, match); rt != nil {
+			return rt, params
+		}
+		return pick(n.prefixes, match), params
+	}
+	seg := segs[0]
+	if child, ok := n.static[seg]; ok {
+		if rt, p := child.lookup(segs[1:], params, match); rt != nil {
+			return rt, p
+		}
+	}
+	for _, pn := range n.params {
+		if pn.check != nil && !pn.check(seg) {
+			continue
+		}
+		if rt, p := pn.child.lookup(segs[1:], append(params, param{name: pn.name, value: seg}), match); rt != nil {
+			return rt, p
+		}
+	}
+	for _, pn := range n.partials {
+		if strings.HasPrefix(seg, pn.prefix) {
+			if rt := pick(pn.routes, match); rt != nil {
+				return rt, params
+			}
+		}
+	}
+	return pick(n.prefixes, match), params
+}
+
+// pick returns the first route that is accepted by the match function.
+func pick(routes []*route, match func(*route) bool) *route {
+	for _, rt := range routes {
+		if match(rt) {
+			return rt
+		}
+	}
+	return nil
+}
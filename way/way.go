@@ -22,7 +22,13 @@ package way
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -30,18 +36,48 @@ import (
 // parameters in context.Context.
 type wayContextKey string
 
+// wayPatternKey is the context key for the pattern of the matched route.
+// It has its own type so that it can't collide with a parameter name.
+type wayPatternKey struct{}
+
+// Middleware wraps a handler with another handler.
+type Middleware func(http.Handler) http.Handler
+
+// Mark returns a handler that serves requests with h and reports what it does
+// to the request, like requiring a token. Middleware in a Group that returns
+// a marked handler has the marks recorded on the routes it is applied to, so
+// that they can be audited from the handlers that really run rather than from
+// names or comments.
+func Mark(h http.Handler, marks ...string) http.Handler {
+	return &markedHandler{Handler: h, marks: marks}
+}
+
+// markedHandler is a handler with the marks from Mark.
+type markedHandler struct {
+	http.Handler
+	marks []string
+}
+
 // Router routes HTTP requests.
 type Router struct {
-	routes []*route
+	routes []*route // in the order they were added
+	root   node
 	// NotFound is the http.Handler to call when no routes
 	// match. By default uses http.NotFoundHandler().
 	NotFound http.Handler
+	// MethodNotAllowed is the http.Handler to call when a route
+	// matches the path but not the method. The Allow header is
+	// set before it is called. By default it returns a 405.
+	MethodNotAllowed http.Handler
 }
 
 // NewRouter makes a new Router.
 func NewRouter() *Router {
 	return &Router{
 		NotFound: http.NotFoundHandler(),
+		MethodNotAllowed: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}),
 	}
 }
 
@@ -53,14 +89,33 @@ func (r *Router) pathSegments(p string) []string {
 // Method can be any HTTP method string or "*" to match all methods.
 // Pattern can contain path segments such as: /item/:id which is
 // accessible via the Param function.
+// A parameter can be constrained by adding a type or a regular expression,
+// as in /item/:id<int>, /system/:at<xyz>, or /user/:name<[a-z]+>.
+// The types are int, uint, and xyz (three integers separated by spaces or commas).
+// Regular expressions must match the entire segment.
+// A request whose parameter doesn't satisfy the constraint doesn't match the route.
+// Handle panics if a constraint is not valid.
 // If pattern ends with trailing /, it acts as a prefix.
+// When more than one route matches a path, static segments are preferred
+// over parameters, and parameters over prefixes.
 func (r *Router) Handle(method, pattern string, handler http.Handler) {
+	r.handle(method, pattern, handler, nil, nil)
+}
+
+// handle adds the route, remembering the middleware wrapped around the handler
+// and the marks that the middleware reported.
+func (r *Router) handle(method, pattern string, handler http.Handler, mw []Middleware, marks []string) {
 	route := &route{
 		method:  strings.ToLower(method),
-		segs:    r.pathSegments(pattern),
+		pattern: pattern,
 		handler: handler,
 		prefix:  strings.HasSuffix(pattern, "/") || strings.HasSuffix(pattern, "..."),
 	}
+	for _, m := range mw {
+		route.middleware = append(route.middleware, middlewareName(m))
+	}
+	route.marks = append(route.marks, marks...)
+	r.root.insert(route, r.pathSegments(pattern))
 	r.routes = append(r.routes, route)
 }
 
@@ -69,21 +124,114 @@ func (r *Router) HandleFunc(method, pattern string, fn http.HandlerFunc) {
 	r.Handle(method, pattern, fn)
 }
 
+// Group returns a group of routes that share the prefix and middleware.
+// The middleware runs only when one of the group's routes matches,
+// in the order given, with the first being the outermost.
+func (r *Router) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{router: r, prefix: strings.TrimSuffix(prefix, "/"), mw: mw}
+}
+
+// Group is a set of routes with a common prefix and middleware.
+type Group struct {
+	router *Router
+	prefix string
+	mw     []Middleware
+}
+
+// Group returns a nested group. Its prefix is appended to the parent's
+// and its middleware runs inside the parent's.
+func (g *Group) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		router: g.router,
+		prefix: g.prefix + strings.TrimSuffix(prefix, "/"),
+		mw:     append(append([]Middleware{}, g.mw...), mw...),
+	}
+}
+
+// Use adds middleware to the group.
+// It only applies to routes added after it is called.
+func (g *Group) Use(mw ...Middleware) {
+	g.mw = append(g.mw, mw...)
+}
+
+// Handle adds a route to the router with the group's prefix and middleware.
+// The pattern is relative to the group's prefix.
+// Marks from middleware that returns a handler from Mark are recorded on the route.
+func (g *Group) Handle(method, pattern string, handler http.Handler) {
+	var marks []string
+	for i := len(g.mw) - 1; i >= 0; i-- {
+		inner := handler
+		handler = g.mw[i](handler)
+		// middleware that passes the handler through unchanged adds no marks.
+		if mh, ok := handler.(*markedHandler); ok && handler != inner {
+			marks = append(append([]string{}, mh.marks...), marks...) // outermost first
+		}
+	}
+	g.router.handle(method, g.prefix+pattern, handler, g.mw, marks)
+}
+
+// HandleFunc is the http.HandlerFunc alternative to Group.Handle.
+func (g *Group) HandleFunc(method, pattern string, fn http.HandlerFunc) {
+	g.Handle(method, pattern, fn)
+}
+
 // ServeHTTP routes the incoming http.Request based on method and path
 // extracting path parameters as it goes.
+// HEAD requests are served by GET routes unless a HEAD route matches first.
+// If routes match the path but not the method, OPTIONS requests are answered
+// with the allowed methods and other requests get MethodNotAllowed.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	method := strings.ToLower(req.Method)
 	segs := r.pathSegments(req.URL.Path)
-	for _, route := range r.routes {
-		if route.method != method && route.method != "*" {
-			continue
+	found, params := r.root.lookup(segs, nil, func(rt *route) bool {
+		return rt.method == method || rt.method == "*"
+	})
+	if found == nil && method == "head" {
+		found, params = r.root.lookup(segs, nil, func(rt *route) bool {
+			return rt.method == "get"
+		})
+	}
+	if found != nil {
+		ctx := req.Context()
+		for _, p := range params {
+			ctx = context.WithValue(ctx, wayContextKey(p.name), p.value)
 		}
-		if ctx, ok := route.match(req.Context(), r, segs); ok {
-			route.handler.ServeHTTP(w, req.WithContext(ctx))
-			return
+		ctx = context.WithValue(ctx, wayPatternKey{}, found.pattern)
+		found.handler.ServeHTTP(w, req.WithContext(ctx))
+		return
+	}
+
+	// find the methods that would have matched the path
+	var pattern string
+	allowed := make(map[string]bool)
+	r.root.lookup(segs, nil, func(rt *route) bool {
+		if pattern == "" {
+			pattern = rt.pattern
 		}
+		allowed[strings.ToUpper(rt.method)] = true
+		return false // keep looking
+	})
+	if len(allowed) == 0 {
+		r.NotFound.ServeHTTP(w, req)
+		return
+	}
+	allowed["OPTIONS"] = true
+	if allowed["GET"] {
+		allowed["HEAD"] = true
+	}
+	var methods []string
+	for m := range allowed {
+		methods = append(methods, m)
 	}
-	r.NotFound.ServeHTTP(w, req)
+	sort.Strings(methods)
+	allow := strings.Join(methods, ", ")
+	w.Header().Set("Allow", allow)
+
+	if method == "options" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	r.MethodNotAllowed.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), wayPatternKey{}, pattern)))
 }
 
 // Param gets the path parameter from the specified Context.
@@ -96,39 +244,114 @@ func Param(ctx context.Context, param string) string {
 	return vStr
 }
 
-type route struct {
-	method  string
-	segs    []string
-	handler http.Handler
-	prefix  bool
+// Pattern gets the pattern of the route that matched the request.
+// Returns an empty string if no route matched.
+func Pattern(ctx context.Context) string {
+	pattern, _ := ctx.Value(wayPatternKey{}).(string)
+	return pattern
+}
+
+// ParamInt gets an integer path parameter from the specified Context.
+// Returns false if the parameter was not found or is not an integer.
+func ParamInt(ctx context.Context, param string) (int, bool) {
+	n, err := strconv.Atoi(Param(ctx, param))
+	return n, err == nil
+}
+
+// XYZ is a set of coordinates from an xyz path parameter.
+type XYZ struct {
+	X, Y, Z int
 }
 
-func (r *route) match(ctx context.Context, router *Router, segs []string) (context.Context, bool) {
-	if len(segs) > len(r.segs) && !r.prefix {
-		return nil, false
+// ParamXYZ gets a coordinates path parameter from the specified Context.
+// Returns false if the parameter was not found or is not three integers.
+func ParamXYZ(ctx context.Context, param string) (XYZ, bool) {
+	return ParseXYZ(Param(ctx, param))
+}
+
+// ParseXYZ parses three integers separated by spaces or commas.
+// Returns false if the string is not three integers.
+func ParseXYZ(s string) (XYZ, bool) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	if len(fields) != 3 {
+		return XYZ{}, false
 	}
-	for i, seg := range r.segs {
-		if i > len(segs)-1 {
-			return nil, false
-		}
-		isParam := false
-		if strings.HasPrefix(seg, ":") {
-			isParam = true
-			seg = strings.TrimPrefix(seg, ":")
+	var c XYZ
+	for i, p := range []*int{&c.X, &c.Y, &c.Z} {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return XYZ{}, false
 		}
-		if !isParam { // verbatim check
-			if strings.HasSuffix(seg, "...") {
-				if strings.HasPrefix(segs[i], seg[:len(seg)-3]) {
-					return ctx, true
-				}
-			}
-			if seg != segs[i] {
-				return nil, false
-			}
-		}
-		if isParam {
-			ctx = context.WithValue(ctx, wayContextKey(seg), segs[i])
+		*p = n
+	}
+	return c, true
+}
+
+// constraints are the named parameter types.
+var constraints = map[string]*regexp.Regexp{
+	"int":  regexp.MustCompile(`^[-+]?[0-9]+$`),
+	"uint": regexp.MustCompile(`^[0-9]+$`),
+	"xyz":  regexp.MustCompile(`^[-+]?[0-9]+[ ,][-+]?[0-9]+[ ,][-+]?[0-9]+$`),
+}
+
+// constraint returns the check for a parameter type or regular expression.
+func constraint(expr string) func(string) bool {
+	re, ok := constraints[expr]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile("^(?:" + expr + ")$"); err != nil {
+			panic(fmt.Sprintf("way: bad constraint <%s>: %v", expr, err))
 		}
 	}
-	return ctx, true
+	return re.MatchString
+}
+
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	Method  string
+	Pattern string
+	// Middleware is the names of the functions that wrap the handler,
+	// outermost first. It is empty for routes added directly to the Router.
+	Middleware []string
+	// Marks are the marks reported by the middleware, outermost first.
+	Marks []string
+}
+
+// Routes returns the registered routes in the order they were added.
+func (r *Router) Routes() []RouteInfo {
+	var list []RouteInfo
+	for _, rt := range r.routes {
+		list = append(list, RouteInfo{
+			Method:     strings.ToUpper(rt.method),
+			Pattern:    rt.pattern,
+			Middleware: append([]string{}, rt.middleware...),
+			Marks:      append([]string{}, rt.marks...),
+		})
+	}
+	return list
+}
+
+// middlewareName returns the name of the middleware's function without the
+// package path, like "main.(*Server).authenticate".
+func middlewareName(mw Middleware) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(mw).Pointer())
+	if fn == nil {
+		return "?"
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm") // method values have a suffix
+}
+
+type route struct {
+	method     string
+	pattern    string
+	handler    http.Handler
+	prefix     bool
+	middleware []string // names of the middleware, outermost first
+	marks      []string // marks reported by the middleware, outermost first
 }
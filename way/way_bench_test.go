@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package way
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// benchRoutes are the application's routes, in the order routes.go registers
+// them, plus the ones we expect to add, so that the router is about the size
+// it will be in production.
+func benchRoutes() [][2]string {
+	const game = "/api/games/:game<[a-zA-Z0-9][-a-zA-Z0-9_]{0,31}>"
+	routes := [][2]string{
+		{"GET", "/api/calc/mishap/:from<xyz>/:to<xyz>/:age<uint>/:gv<uint>"},
+		{"GET", "/healthz"}, {"GET", "/readyz"}, {"GET", "/.well-known/jwks.json"},
+		{"POST", game + "/login"}, {"GET", game + "/version"},
+		{"GET", "/api/games"}, {"POST", "/api/logout"}, {"POST", "/api/token/refresh"},
+		{"GET", game + "/events"}, {"POST", game + "/messages"}, {"POST", game + "/orders"},
+		{"GET", game + "/planet/:id<.{5,32}>"}, {"GET", game + "/planets"},
+		{"GET", game + "/species"}, {"GET", game + "/species/:id<int>"},
+		{"GET", game + "/system/:id<xyz>"}, {"GET", game + "/systems"},
+		{"GET", game + "/turn"}, {"GET", game + "/user"},
+		{"GET", game + "/admin/audit"}, {"POST", game + "/admin/reload"}, {"GET", game + "/admin/routes"},
+		{"POST", game + "/admin/save"}, {"GET", game + "/admin/species/:id<int>"},
+		{"GET", game + "/admin/stats"}, {"PUT", game + "/admin/turn"},
+		{"POST", "/api/admin/reload"}, {"GET", "/api/admin/routes"}, {"GET", "/api/admin/sessions"},
+		{"GET", "/metrics"}, {"GET", "/static/"}, {"GET", "/img/pic..."},
+	}
+	for _, kind := range []string{"colonies", "ships", "fleets", "reports"} {
+		routes = append(routes,
+			[2]string{"GET", game + "/" + kind},
+			[2]string{"GET", game + "/" + kind + "/:id<int>"},
+			[2]string{"POST", game + "/" + kind},
+			[2]string{"PUT", game + "/" + kind + "/:id<int>"},
+			[2]string{"DELETE", game + "/" + kind + "/:id<int>"},
+			[2]string{"GET", game + "/admin/" + kind},
+			[2]string{"GET", game + "/admin/" + kind + "/:id<int>"})
+	}
+	return routes
+}
+
+func benchRouter() *Router {
+	r := NewRouter()
+	for _, rt := range benchRoutes() {
+		r.HandleFunc(rt[0], rt[1], func(w http.ResponseWriter, req *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s id=%s", Pattern(req.Context()), Param(req.Context(), "id"))
+		})
+	}
+	return r
+}
+
+func benchRequest(b *testing.B, method, path string) {
+	r := benchRouter()
+	req := httptest.NewRequest(method, "http://example.com"+strings.ReplaceAll(path, " ", "%20"), nil)
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Body.Reset()
+		r.ServeHTTP(w, req)
+	}
+}
+
+// BenchmarkFirstRoute matches the first route registered.
+func BenchmarkFirstRoute(b *testing.B) {
+	benchRequest(b, "GET", "/api/calc/mishap/1 2 3/4 5 6/2/50")
+}
+
+// BenchmarkGameRoute matches a route in a game, which most requests are.
+func BenchmarkGameRoute(b *testing.B) {
+	benchRequest(b, "GET", "/api/games/alpha/species/3")
+}
+
+// BenchmarkLastRoute matches the last route registered,
+// which was the worst case for the router that scanned a list.
+func BenchmarkLastRoute(b *testing.B) {
+	benchRequest(b, "GET", "/api/games/alpha/admin/reports/5")
+}
+
+// BenchmarkUnmatched requests a path that no route matches.
+func BenchmarkUnmatched(b *testing.B) {
+	benchRequest(b, "GET", "/nope/nope")
+}
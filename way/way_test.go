@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package way
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestRouter returns a router whose handlers write the matched pattern
+// followed by the requested parameters.
+func newTestRouter(routes [][2]string, params ...string) *Router {
+	r := NewRouter()
+	for _, rt := range routes {
+		r.HandleFunc(rt[0], rt[1], func(w http.ResponseWriter, req *http.Request) {
+			body := Pattern(req.Context())
+			for _, name := range params {
+				body += fmt.Sprintf(" %s=%s", name, Param(req.Context(), name))
+			}
+			_, _ = fmt.Fprint(w, body)
+		})
+	}
+	return r
+}
+
+func serve(r *Router, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, "http://example.com"+strings.ReplaceAll(path, " ", "%20"), nil))
+	return w
+}
+
+func TestPriority(t *testing.T) {
+	// registered in the reverse of their priority.
+	r := newTestRouter([][2]string{
+		{"GET", "/p/"},
+		{"GET", "/p/b..."},
+		{"GET", "/p/:id<int>"},
+		{"GET", "/p/bc"},
+	}, "id")
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/p/bc", "/p/bc id="},
+		{"/p/12", "/p/:id<int> id=12"},
+		{"/p/bd", "/p/b... id="},
+		{"/p/bc/x", "/p/b... id="}, // the static branch has no route for the rest of the path
+		{"/p/zz", "/p/ id="},
+		{"/p/12/x", "/p/ id="},
+	} {
+		if w := serve(r, "GET", tc.path); w.Code != http.StatusOK || w.Body.String() != tc.want {
+			t.Errorf("GET %s: want 200 %q, got %d %q", tc.path, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestBacktrackOnMethod(t *testing.T) {
+	r := newTestRouter([][2]string{
+		{"POST", "/m/new"},
+		{"GET", "/m/:id"},
+		{"PUT", "/m/:id/x"},
+		{"GET", "/m/"},
+	}, "id")
+	for _, tc := range []struct {
+		method, path, want string
+	}{
+		{"POST", "/m/new", "/m/new id="},
+		{"GET", "/m/new", "/m/:id id=new"},
+		{"HEAD", "/m/new", "/m/:id id=new"},
+		{"PUT", "/m/new/x", "/m/:id/x id=new"},
+		{"GET", "/m/new/x", "/m/ id="},
+	} {
+		if w := serve(r, tc.method, tc.path); w.Code != http.StatusOK || w.Body.String() != tc.want {
+			t.Errorf("%s %s: want 200 %q, got %d %q", tc.method, tc.path, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestParamsAfterFailedBranch(t *testing.T) {
+	r := newTestRouter([][2]string{
+		{"GET", "/f/:a/:c/x"},
+		{"GET", "/f/:b<int>/:d/y"},
+		{"DELETE", "/f/:e/:g/y"},
+	}, "a", "b", "c", "d", "e", "g")
+	for _, tc := range []struct {
+		method, path, want string
+	}{
+		{"GET", "/f/1/2/x", "/f/:a/:c/x a=1 b= c=2 d= e= g="},
+		{"GET", "/f/1/2/y", "/f/:b<int>/:d/y a= b=1 c= d=2 e= g="},
+		{"DELETE", "/f/1/2/y", "/f/:e/:g/y a= b= c= d= e=1 g=2"},
+	} {
+		if w := serve(r, tc.method, tc.path); w.Code != http.StatusOK || w.Body.String() != tc.want {
+			t.Errorf("%s %s: want 200 %q, got %d %q", tc.method, tc.path, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	r := newTestRouter([][2]string{
+		{"GET", "/t"},
+		{"PUT", "/t"},
+		{"POST", "/t/:id"},
+	})
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		allow        string
+	}{
+		{"DELETE", "/t", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, PUT"},
+		{"OPTIONS", "/t", http.StatusNoContent, "GET, HEAD, OPTIONS, PUT"},
+		{"GET", "/t/1", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{"OPTIONS", "/t/1", http.StatusNoContent, "OPTIONS, POST"},
+		{"GET", "/u", http.StatusNotFound, ""},
+	} {
+		w := serve(r, tc.method, tc.path)
+		if w.Code != tc.code || w.Header().Get("Allow") != tc.allow {
+			t.Errorf("%s %s: want %d %q, got %d %q", tc.method, tc.path, tc.code, tc.allow, w.Code, w.Header().Get("Allow"))
+		}
+	}
+
+	// CORS preflight headers are left to the CORS middleware, which knows the allowed origins.
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("OPTIONS", "http://example.com/t", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, HEAD, OPTIONS, PUT" {
+		t.Errorf("preflight: want 204 %q, got %d %q", "GET, HEAD, OPTIONS, PUT", w.Code, w.Header().Get("Allow"))
+	} else if got := w.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("preflight: want no Access-Control-Allow-Methods, got %q", got)
+	}
+}
+
+// TestPrefixes checks that prefix and "..." routes behave as they did
+// before the router was rebuilt on a tree.
+func TestPrefixes(t *testing.T) {
+	r := newTestRouter([][2]string{
+		{"GET", "/static/"},
+		{"GET", "/img/pic..."},
+		{"GET", "/"},
+	})
+	for _, tc := range []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/static/", http.StatusOK, "/static/"},
+		{"/static", http.StatusOK, "/static/"},
+		{"/static/a/b/c", http.StatusOK, "/static/"},
+		{"/img/pic", http.StatusOK, "/img/pic..."},
+		{"/img/picture.png", http.StatusOK, "/img/pic..."},
+		{"/img/picture.png/x", http.StatusOK, "/img/pic..."},
+		{"/", http.StatusOK, "/"},
+	} {
+		w := serve(r, "GET", tc.path)
+		if w.Code != tc.code || w.Body.String() != tc.want {
+			t.Errorf("GET %s: want %d %q, got %d %q", tc.path, tc.code, tc.want, w.Code, w.Body.String())
+		}
+	}
+
+	// "/" only matches the root; it is not a catch-all.
+	for _, path := range []string{"/img/pxx", "/img", "/nope", "/nope/nope"} {
+		if w := serve(r, "GET", path); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: want 404, got %d %q", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestMarks checks that routes record the marks of the middleware that wraps them,
+// outermost first, and nothing for middleware that doesn't report any.
+func TestMarks(t *testing.T) {
+	mark := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return Mark(h, name)
+		}
+	}
+	plain := func(h http.Handler) http.Handler {
+		return h
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r := NewRouter()
+	r.HandleFunc("GET", "/bare", ok)
+	api := r.Group("/api", mark("outer"), plain)
+	api.HandleFunc("GET", "/a", ok)
+	api.Group("/b", mark("inner")).HandleFunc("GET", "/c", ok)
+
+	want := map[string]string{
+		"/bare":    "",
+		"/api/a":   "outer",
+		"/api/b/c": "outer inner",
+	}
+	for _, ri := range r.Routes() {
+		if got := strings.Join(ri.Marks, " "); got != want[ri.Pattern] {
+			t.Errorf("%s: want marks %q, got %q", ri.Pattern, want[ri.Pattern], got)
+		}
+	}
+	if w := serve(r, "GET", "/api/b/c"); w.Code != http.StatusOK {
+		t.Errorf("GET /api/b/c: want 200, got %d", w.Code)
+	}
+}